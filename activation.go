@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrNotElementwise is returned by ActivatePrime for ActivationSoftmax,
+// whose derivative is a matrix per sample; see softmaxPrime.
+var ErrNotElementwise = errors.New("nn: activation is not elementwise")
+
+// Activation selects the function a layer applies to its outputs. Each is
+// registered in activations with a fixed point implementation, its
+// derivative and float64 versions of both for the Float64 backend. The
+// derivatives are written in terms of the output y = f(x) rather than x, so
+// Backward needs only what Forward returned.
+type Activation int
+
+const (
+	// ActivationSigmoid is the logistic function, computed as the Network's
+	// Squash selects
+	ActivationSigmoid Activation = iota
+	// ActivationReLU is max(x, 0)
+	ActivationReLU
+	// ActivationLeakyReLU is x above zero and x/100 below
+	ActivationLeakyReLU
+	// ActivationELU is x above zero and e^x - 1 below
+	ActivationELU
+	ActivationTanh
+	// ActivationHardTanh is x clamped to [-1, 1]
+	ActivationHardTanh
+	// ActivationLinear is x itself, for regression outputs
+	ActivationLinear
+	// ActivationSoftplus is ln(1 + e^x), a smooth ReLU
+	ActivationSoftplus
+	// ActivationSoftmax is e^x over the sum of e^x of the column, a
+	// probability per class for the output layer. It is not elementwise, so
+	// it has no entry in activations
+	ActivationSoftmax
+	numActivations
+)
+
+var activationNames = [numActivations]string{"sigmoid", "relu", "leaky-relu", "elu", "tanh", "hard-tanh", "linear", "softplus", "softmax"}
+
+func (f Activation) String() string {
+	return activationNames[f]
+}
+
+// ParseActivation is the inverse of Activation.String.
+func ParseActivation(name string) (Activation, bool) {
+	for f, n := range activationNames {
+		if n == name {
+			return Activation(f), true
+		}
+	}
+	return ActivationSigmoid, false
+}
+
+// activation is an entry of the registry. fn and prime have the signature
+// ApplyErr takes; prime is given y = fn(x).
+type activation struct {
+	fn, prime             func(r, c int, z fixed) fixed
+	float64, float64Prime func(x float64) float64
+}
+
+var activations = [numActivations]activation{
+	ActivationSigmoid: {
+		sigmoid, sigmoidp,
+		func(x float64) float64 { return 1 / (1 + math.Exp(-x)) },
+		func(y float64) float64 { return y * (1 - y) },
+	},
+	ActivationReLU: {
+		relu, relup,
+		func(x float64) float64 { return math.Max(x, 0) },
+		func(y float64) float64 { return step(y > 0, 1, 0) },
+	},
+	ActivationLeakyReLU: {
+		leakyRelu, leakyRelup,
+		func(x float64) float64 { return step(x > 0, x, x/100) },
+		func(y float64) float64 { return step(y > 0, 1, 0.01) },
+	},
+	ActivationELU: {
+		elu, elup,
+		func(x float64) float64 { return step(x > 0, x, math.Expm1(x)) },
+		func(y float64) float64 { return step(y > 0, 1, y+1) },
+	},
+	ActivationTanh: {
+		func(r, c int, z fixed) fixed { return tanh(z) }, tanhp,
+		math.Tanh,
+		func(y float64) float64 { return 1 - y*y },
+	},
+	ActivationHardTanh: {
+		hardTanh, hardTanhp,
+		func(x float64) float64 { return math.Max(-1, math.Min(x, 1)) },
+		func(y float64) float64 { return step(y > -1 && y < 1, 1, 0) },
+	},
+	ActivationLinear: {
+		func(r, c int, z fixed) fixed { return z },
+		func(r, c int, z fixed) fixed { return ONE },
+		func(x float64) float64 { return x },
+		func(y float64) float64 { return 1 },
+	},
+	ActivationSoftplus: {
+		softplus, softplusp,
+		func(x float64) float64 { return math.Max(x, 0) + math.Log1p(math.Exp(-math.Abs(x))) },
+		func(y float64) float64 { return -math.Expm1(-y) },
+	},
+}
+
+// fn returns f in fixed point, the sigmoid as s selects.
+func (f Activation) fn(s Squash) func(r, c int, z fixed) fixed {
+	if f == ActivationSigmoid {
+		return s.fn()
+	}
+	return activations[f].fn
+}
+
+func step(cond bool, a, b float64) float64 {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// sigmoidp is y(1 - y), whichever Squash gave y.
+func sigmoidp(r, c int, y fixed) fixed {
+	return MultiplyFixed(y, subFixed(ONE, y, opSub))
+}
+
+func relu(r, c int, z fixed) fixed {
+	if z > 0 {
+		return z
+	}
+	return 0
+}
+
+func relup(r, c int, y fixed) fixed {
+	if y > 0 {
+		return ONE
+	}
+	return 0
+}
+
+func leakyRelu(r, c int, z fixed) fixed {
+	if z > 0 {
+		return z
+	}
+	return MultiplyFixed(z, leakySlope)
+}
+
+func leakyRelup(r, c int, y fixed) fixed {
+	if y > 0 {
+		return ONE
+	}
+	return leakySlope
+}
+
+func elu(r, c int, z fixed) fixed {
+	if z > 0 {
+		return z
+	}
+	return exp(z) - ONE
+}
+
+// elup is e^x = y + 1 below zero.
+func elup(r, c int, y fixed) fixed {
+	if y > 0 {
+		return ONE
+	}
+	return y + ONE
+}
+
+func tanhp(r, c int, y fixed) fixed {
+	return subFixed(ONE, MultiplyFixed(y, y), opSub)
+}
+
+func hardTanh(r, c int, z fixed) fixed {
+	return fixedMax(-ONE, fixedMin(z, ONE))
+}
+
+func hardTanhp(r, c int, y fixed) fixed {
+	if y > -ONE && y < ONE {
+		return ONE
+	}
+	return 0
+}
+
+// softplus is max(z, 0) + ln(1 + e^-|z|), so exp cannot overflow.
+func softplus(r, c int, z fixed) fixed {
+	if z > 0 {
+		return addFixed(z, ln(ONE+exp(-z)), opAdd)
+	}
+	return ln(ONE + exp(z))
+}
+
+// softplusp is the sigmoid of x, 1 - e^-y.
+func softplusp(r, c int, y fixed) fixed {
+	return ONE - exp(-y)
+}
+
+// Activate sets m to f of a, computing the sigmoid as s selects.
+func (m *Matrix) Activate(f Activation, s Squash, a Mat) error {
+	if f == ActivationSoftmax {
+		return m.softmax(AsMatrix(a))
+	}
+	return m.ApplyErr(f.fn(s), a)
+}
+
+// ActivatePrime sets m to the derivative of f at the x for which a = f(x).
+func (m *Matrix) ActivatePrime(f Activation, a Mat) error {
+	if f == ActivationSoftmax {
+		return ErrNotElementwise
+	}
+	return m.ApplyErr(activations[f].prime, a)
+}
+
+// softmax sets every column of m to the softmax of that column of a. The
+// largest element is subtracted first, so every exp is at most ONE and their
+// sum, kept exactly in int64 rather than in the format, cannot overflow even
+// in narrow formats.
+func (m *Matrix) softmax(a *Matrix) error {
+	if err := m.holds("Activate", a.row, a.col); err != nil {
+		return err
+	}
+	e := make([]fixed, a.row)
+	for j := 0; j < a.col; j++ {
+		max := MIN
+		for i := 0; i < a.row; i++ {
+			max = fixedMax(max, a.at(i, j))
+		}
+		var sum fixed
+		for i := 0; i < a.row; i++ {
+			// max - x as an exact magnitude, which may not fit the format
+			if d := uint64(max - a.at(i, j)); d <= uint64(MAX) {
+				e[i] = exp(-fixed(d))
+			} else {
+				e[i] = 0
+			}
+			sum += e[i]
+		}
+		for i := 0; i < a.row; i++ {
+			m.set(i, j, DivideFixed(e[i], sum))
+		}
+	}
+	return nil
+}
+
+// softmaxPrime is the product of the Jacobian of the softmax, at the outputs
+// out, with errs: out * (errs - the column sums of out * errs).
+func softmaxPrime(out, errs Mat) (Mat, error) {
+	p, err := multiplyErr(out, errs)
+	if err != nil {
+		return nil, err
+	}
+	_, c := p.Dims()
+	sums := p.New(1, c)
+	if err := sums.SumAlongErr(p, AlongCols); err != nil {
+		return nil, err
+	}
+	d := errs.New(errs.Dims())
+	if err := d.SubBroadcastErr(errs, sums); err != nil {
+		return nil, err
+	}
+	return multiplyErr(out, d)
+}
+
+// Activate is f of a in float64; the sigmoid is exact whatever s is.
+func (m *FloatMatrix) Activate(f Activation, s Squash, x Mat) error {
+	if f == ActivationSoftmax {
+		return m.softmax(AsFloatMatrix(x))
+	}
+	return m.applyFloat("Activate", activations[f].float64, x)
+}
+
+func (m *FloatMatrix) ActivatePrime(f Activation, x Mat) error {
+	if f == ActivationSoftmax {
+		return ErrNotElementwise
+	}
+	return m.applyFloat("ActivatePrime", activations[f].float64Prime, x)
+}
+
+func (m *FloatMatrix) softmax(a *FloatMatrix) error {
+	if err := m.holds("Activate", a.row, a.col); err != nil {
+		return err
+	}
+	for j := 0; j < a.col; j++ {
+		max := math.Inf(-1)
+		for i := 0; i < a.row; i++ {
+			max = math.Max(max, a.data[i*a.col+j])
+		}
+		var sum float64
+		for i := 0; i < a.row; i++ {
+			m.data[i*a.col+j] = math.Exp(a.data[i*a.col+j] - max)
+			sum += m.data[i*a.col+j]
+		}
+		for i := 0; i < a.row; i++ {
+			m.data[i*a.col+j] /= sum
+		}
+	}
+	return nil
+}
+
+func (m *FloatMatrix) applyFloat(op string, fn func(x float64) float64, x Mat) error {
+	a := AsFloatMatrix(x)
+	if err := m.holds(op, a.row, a.col); err != nil {
+		return err
+	}
+	for i, v := range a.data {
+		m.data[i] = fn(v)
+	}
+	return nil
+}
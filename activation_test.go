@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// activationPoints are inputs the activations are checked at, away from
+// the kinks of ReLU, its variants and HardTanh.
+var activationPoints = []float64{-8, -3.5, -1.5, -0.75, -0.25, -1.0 / 64, 1.0 / 64, 0.25, 0.5, 0.9, 1.25, 2, 4.5, 8}
+
+// TestActivations checks each registered activation and its derivative in
+// fixed point against float64, and the float64 derivatives, given the
+// output, against central differences of the functions.
+func TestActivations(t *testing.T) {
+	for f := Activation(0); f < numActivations; f++ {
+		if f == ActivationSoftmax {
+			continue
+		}
+		a := activations[f]
+		for _, fm := range []Format{Q16_48, Q8_24} {
+			withFormat(t, fm, Wrap, RoundHalfEven, func() {
+				// exp, ln and tanh are within a few ulps, and 0.01 is
+				// rounded in leakySlope
+				tol := math.Ldexp(16, -fm.FracBits)
+				for _, x := range activationPoints {
+					y := f.fn(SquashExact)(0, 0, floatToFixed(x))
+					if want := a.float64(x); math.Abs(toFloat(y)-want) > tol*math.Max(1, math.Abs(want)) {
+						t.Errorf("%v %v: f(%v) = %v, want %v", fm, f, x, toFloat(y), want)
+					}
+					if got, want := toFloat(a.prime(0, 0, y)), a.float64Prime(toFloat(y)); math.Abs(got-want) > tol {
+						t.Errorf("%v %v: f' at f(%v) = %v = %v, want %v", fm, f, x, toFloat(y), got, want)
+					}
+				}
+			})
+		}
+		const h = 1e-6
+		for _, x := range activationPoints {
+			d := (a.float64(x+h) - a.float64(x-h)) / (2 * h)
+			if got := a.float64Prime(a.float64(x)); math.Abs(got-d) > 1e-6 {
+				t.Errorf("%v: f' at %v = %v, want %v", f, x, got, d)
+			}
+		}
+
+		// both backends through Mat, and the name round trip
+		for _, b := range []Backend{FixedPoint, Float64} {
+			x := b.matrix(1, len(activationPoints), nil)
+			for i, v := range activationPoints {
+				x.Set(0, i, floatToFixed(v))
+			}
+			y, p := x.New(x.Dims()), x.New(x.Dims())
+			if err := y.Activate(f, SquashExact, x); err != nil {
+				t.Fatalf("%v %v: Activate: %v", b, f, err)
+			}
+			if err := p.ActivatePrime(f, y); err != nil {
+				t.Fatalf("%v %v: ActivatePrime: %v", b, f, err)
+			}
+			for i, v := range activationPoints {
+				if got, want := p.AtFloat(0, i), a.float64Prime(a.float64(v)); math.Abs(got-want) > 1e-9 {
+					t.Errorf("%v %v: ActivatePrime at %v = %v, want %v", b, f, v, got, want)
+				}
+			}
+		}
+	}
+	for f := Activation(0); f < numActivations; f++ {
+		if g, ok := ParseActivation(f.String()); !ok || g != f {
+			t.Errorf("ParseActivation(%q) = %v, %v", f.String(), g, ok)
+		}
+	}
+	if _, ok := ParseActivation("swish"); ok {
+		t.Error("ParseActivation accepted swish")
+	}
+}
+
+// TestSigmoidSquash checks that the sigmoid activation computes as the
+// Squash selects, in fixed point only.
+func TestSigmoidSquash(t *testing.T) {
+	x := NewMatrix(1, len(activationPoints), nil)
+	for i, v := range activationPoints {
+		x.Set(0, i, floatToFixed(v))
+	}
+	for s := Squash(0); s < numSquashes; s++ {
+		y := NewMatrix(1, len(activationPoints), nil)
+		if err := y.Activate(ActivationSigmoid, s, x); err != nil {
+			t.Fatal(err)
+		}
+		for i := range activationPoints {
+			if got, want := y.At(0, i), s.fn()(0, i, x.At(0, i)); got != want {
+				t.Errorf("%v: sigmoid(%v) = %d, want %d", s, activationPoints[i], got, want)
+			}
+		}
+	}
+}
+
+func TestSoftmax(t *testing.T) {
+	rng := rand.New(rand.NewSource(19))
+	for _, fm := range []Format{Q16_48, Q8_24, Q8_8} {
+		withFormat(t, fm, Saturate, RoundHalfEven, func() {
+			x := NewMatrix(5, 4, nil)
+			for i := 0; i < 5; i++ {
+				for j := 0; j < 3; j++ {
+					x.Set(i, j, floatToFixed(rng.Float64()*8-4))
+				}
+			}
+			// a column spanning the whole format, whose differences do not
+			// fit it
+			for i, v := range []fixed{MAX, MIN, 0, MAX - ONE, -ONE} {
+				x.Set(i, 3, v)
+			}
+			y := NewMatrix(5, 4, nil)
+			if err := y.Activate(ActivationSoftmax, SquashExact, x); err != nil {
+				t.Fatal(err)
+			}
+			want := NewFloatMatrix(5, 4, nil)
+			if err := want.Activate(ActivationSoftmax, SquashExact, x); err != nil {
+				t.Fatal(err)
+			}
+			tol := math.Ldexp(8, -fm.FracBits)
+			wd, _ := ToDense(want)
+			if i, j, ok := closeTo(y, wd, tol); !ok {
+				t.Errorf("%v: softmax (%d, %d) = %v, want %v", fm, i, j, y.AtFloat(i, j), wd.At(i, j))
+			}
+			sums := SumAlong(y, AlongCols)
+			for j := 0; j < 4; j++ {
+				if s := toFloat(sums.At(0, j)); math.Abs(s-1) > tol {
+					t.Errorf("%v: column %d sums to %v", fm, j, s)
+				}
+			}
+		})
+	}
+
+	for _, m := range []Mat{NewMatrix(2, 2, nil), NewFloatMatrix(2, 2, nil)} {
+		if err := m.ActivatePrime(ActivationSoftmax, m.New(2, 2)); !errors.Is(err, ErrNotElementwise) {
+			t.Errorf("%T ActivatePrime of softmax gave %v, want ErrNotElementwise", m, err)
+		}
+	}
+}
+
+// TestSoftmaxPrime checks softmaxPrime on both backends against the
+// Jacobian of the float64 softmax by central differences times the errors.
+func TestSoftmaxPrime(t *testing.T) {
+	rng := rand.New(rand.NewSource(20))
+	const h = 1e-6
+	x := NewFloatMatrix(4, 3, nil)
+	errs := NewFloatMatrix(4, 3, nil)
+	for i := range x.data {
+		x.data[i] = rng.Float64()*4 - 2
+		errs.data[i] = rng.Float64()*2 - 1
+	}
+	softmax := func(x *FloatMatrix) *FloatMatrix {
+		y := NewFloatMatrix(4, 3, nil)
+		y.Activate(ActivationSoftmax, SquashExact, x)
+		return y
+	}
+	out := softmax(x)
+	want := NewFloatMatrix(4, 3, nil)
+	for j := 0; j < 3; j++ {
+		for k := 0; k < 4; k++ {
+			// column k of the Jacobian of column j
+			hi := NewFloatMatrix(4, 3, append([]float64(nil), x.data...))
+			lo := NewFloatMatrix(4, 3, append([]float64(nil), x.data...))
+			hi.data[k*3+j] += h
+			lo.data[k*3+j] -= h
+			sh, sl := softmax(hi), softmax(lo)
+			for i := 0; i < 4; i++ {
+				want.data[i*3+j] += (sh.data[i*3+j] - sl.data[i*3+j]) / (2 * h) * errs.data[k*3+j]
+			}
+		}
+	}
+	wd, _ := ToDense(want)
+	for _, b := range []Backend{FixedPoint, Float64} {
+		got, err := softmaxPrime(b.convert(out), b.convert(errs))
+		if err != nil {
+			t.Fatalf("%v: %v", b, err)
+		}
+		if i, j, ok := closeTo(got, wd, 1e-8); !ok {
+			t.Errorf("%v: softmaxPrime (%d, %d) = %v, want %v", b, i, j, got.AtFloat(i, j), wd.At(i, j))
+		}
+	}
+}
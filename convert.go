@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+var (
+	ErrNaN    = errors.New("fixed: NaN has no fixed point value")
+	ErrSyntax = errors.New("fixed: invalid syntax")
+)
+
+// The plain conversions round with the package rounding mode and wrap or
+// saturate values outside the active format according to the arithmetic
+// mode, counting them as conv overflows. The Err and Round variants return
+// the same value together with ErrOverflow.
+
+// toInt returns the integer part of x, rounded toward minus infinity like
+// floor.
+func toInt(x fixed) int64 {
+	return int64(x >> fracBits)
+}
+
+// ToIntRound rounds x to an integer with r.
+func ToIntRound(x fixed, r Rounding) int64 {
+	return int64(int128{uint64(int64(x) >> 63), uint64(x)}.shr(fracBits, r).lo)
+}
+
+func intToFixed(x int) fixed {
+	v, _ := IntToFixedErr(x)
+	return v
+}
+
+func IntToFixedErr(x int) (fixed, error) {
+	if int64(x) > int64(MAX>>fracBits) || int64(x) < int64(MIN>>fracBits) {
+		return overflowed(opConv, x > 0, fixed(x)<<fracBits), ErrOverflow
+	}
+	return fixed(x) << fracBits, nil
+}
+
+func floatToFixed(a float64) fixed {
+	v, _ := FloatToFixedRound(a, rounding)
+	return v
+}
+
+func FloatToFixedErr(a float64) (fixed, error) {
+	return FloatToFixedRound(a, rounding)
+}
+
+// FloatToFixedRound converts a exactly and then rounds it to the active
+// format with r, so zero, subnormals and values below half an ulp all come
+// out right. NaN gives 0 and ErrNaN; infinities give MAX or MIN whatever the
+// arithmetic mode.
+func FloatToFixedRound(a float64, r Rounding) (fixed, error) {
+	switch {
+	case math.IsNaN(a):
+		return 0, ErrNaN
+	case math.IsInf(a, 0):
+		overflowed(opConv, a > 0, 0)
+		if a < 0 {
+			return MIN, ErrOverflow
+		}
+		return MAX, ErrOverflow
+	case a == 0:
+		return 0, nil
+	}
+
+	// |a| * 2^fracBits = m * 2^s with m an integer of at most 53 bits
+	frac, e := math.Frexp(math.Abs(a))
+	m := uint64(math.Ldexp(frac, 53))
+	s := e - 53 + int(fracBits)
+
+	x := int128{0, m}
+	switch {
+	case s >= 64:
+		// the low 64 bits are all zero, which is what wrapping keeps
+		return overflowed(opConv, a > 0, 0), ErrOverflow
+	case s > 0:
+		x = int128{m >> (64 - uint(s)), m << uint(s)}
+	case s <= -64:
+		// well below half an ulp, only flooring a negative value moves it
+		if r == Truncate && a < 0 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	if a < 0 {
+		x = x.neg()
+	}
+	if s < 0 {
+		x = x.shr(uint(-s), r)
+	}
+	return x.fixedErr(opConv)
+}
+
+func Float32ToFixedRound(a float32, r Rounding) (fixed, error) {
+	return FloatToFixedRound(float64(a), r)
+}
+
+// toFloat and toFloat32 round once, to the nearest float; every format has
+// few enough fractional bits that the scaling itself is exact.
+func toFloat(a fixed) float64 {
+	return math.Ldexp(float64(int64(a)), -int(fracBits))
+}
+
+func toFloat32(a fixed) float32 {
+	return float32(int64(a)) * float32(math.Ldexp(1, -int(fracBits)))
+}
+
+// String writes f in decimal with the fewest fractional digits that
+// ParseFixed reads back to f. The digits are generated as in Steele and
+// White's free-format algorithm: stop as soon as the rest of the expansion,
+// or its complement to the next digit, is within half an ulp.
+func (f fixed) String() string {
+	var b []byte
+	if f < 0 {
+		b = append(b, '-')
+	}
+	m := magnitude(f)
+	b = strconv.AppendUint(b, m>>fracBits, 10)
+	if m&uint64(fracMask) == 0 {
+		return string(b)
+	}
+	b = append(b, '.')
+
+	// rest and margin count units of half an ulp
+	s := fracBits + 1
+	one := uint64(1) << s
+	rest, margin := (m&uint64(fracMask))<<1, uint64(1)
+	for {
+		hi, lo := bits.Mul64(rest, 10)
+		digit := hi<<(64-s) | lo>>s
+		rest = lo & (one - 1)
+		hi, lo = bits.Mul64(margin, 10)
+		margin = lo
+		if hi != 0 || margin > one {
+			margin = one
+		}
+		low, high := rest < margin, rest > one-margin
+		if low || high {
+			if high && (!low || rest > one>>1) {
+				digit++
+			}
+			return string(append(b, byte('0'+digit)))
+		}
+		b = append(b, byte('0'+digit))
+	}
+}
+
+// ParseFixed reads a decimal like "-3.1415" rounded to nearest, ties to
+// even, which makes it the inverse of fixed.String.
+func ParseFixed(s string) (fixed, error) {
+	return ParseFixedRound(s, RoundHalfEven)
+}
+
+// ParseFixedRound reads an optionally signed decimal with an optional
+// fraction and rounds it to the active format with r. There is no
+// limit on the number of fractional digits.
+func ParseFixedRound(s string, r Rounding) (fixed, error) {
+	str := s
+	isNegative := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		isNegative = s[0] == '-'
+		s = s[1:]
+	}
+
+	// the integer part is kept modulo 2^64, which is what wrapping needs
+	var ip uint64
+	tooBig := false
+	digits := 0
+	i := 0
+	for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		hi, lo := bits.Mul64(ip, 10)
+		lo, carry := bits.Add64(lo, uint64(s[i]-'0'), 0)
+		tooBig = tooBig || hi != 0 || carry != 0
+		ip = lo
+		digits++
+	}
+	// the fraction as 64 binary digits plus a sticky bit for the rest,
+	// built with Horner's rule from the last decimal digit: every step is
+	// exact but for the remainder, which only sets the sticky bit
+	var frac uint64
+	sticky := false
+	if i < len(s) && s[i] == '.' {
+		i++
+		start := i
+		for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		}
+		digits += i - start
+		for j := i - 1; j >= start; j-- {
+			var rem uint64
+			frac, rem = bits.Div64(uint64(s[j]-'0'), frac, 10)
+			sticky = sticky || rem != 0
+		}
+	}
+	if i != len(s) || digits == 0 {
+		return 0, fmt.Errorf("%w: %q", ErrSyntax, str)
+	}
+
+	n := 64 - fracBits
+	q, rem, den := frac>>n, frac&(uint64(1)<<n-1), uint64(1)<<n
+	// the sticky bit puts the remainder just above rem, which only matters
+	// when rem is zero or exactly half
+	if sticky && (rem == 0 || rem == den-rem) {
+		rem++
+	}
+	if roundsUp(q, rem, den, isNegative, r) {
+		q++
+	}
+
+	tooBig = tooBig || ip > uint64(1)<<(63-fracBits)
+	m := ip<<fracBits + q
+	v := fixed(m)
+	if isNegative {
+		v = -v
+	}
+	if tooBig || (!isNegative && m > uint64(MAX)) || (isNegative && m > uint64(MAX)+1) {
+		return overflowed(opConv, !isNegative, v), ErrOverflow
+	}
+	return v, nil
+}
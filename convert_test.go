@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// TestStringRoundTrip checks that ParseFixed reads fixed.String back
+// exactly, and that String uses as few fractional digits as it can: the
+// nearest decimal with one digit less is a different value.
+func TestStringRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(12))
+	for _, f := range testFormats {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			check := func(x fixed) {
+				s := x.String()
+				if got, err := ParseFixed(s); got != x || err != nil {
+					t.Fatalf("%v: ParseFixed(%q) = %d, %v, want %d", f, s, got, err, x)
+				}
+				dot := strings.IndexByte(s, '.')
+				if dot < 0 {
+					return
+				}
+				// x * 10^(k-1) rounded to an integer, for k fractional digits
+				k := len(s) - dot - 1
+				v := new(big.Rat).SetFrac(big.NewInt(int64(x)), new(big.Int).Lsh(big.NewInt(1), fracBits))
+				v.Mul(v, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(k-1)), nil)))
+				n := new(big.Int).Quo(new(big.Int).Add(new(big.Int).Mul(v.Num(), big.NewInt(2)), v.Denom()), new(big.Int).Mul(v.Denom(), big.NewInt(2)))
+				shorter := new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(k-1)), nil)).FloatString(k - 1)
+				if got, _ := ParseFixed(shorter); got == x {
+					t.Fatalf("%v: %d is %q, but %q reads back too", f, x, s, shorter)
+				}
+			}
+			for _, x := range edges() {
+				check(x)
+			}
+			for i := 0; i < 20000; i++ {
+				check(randomFixed(rng))
+			}
+		})
+	}
+}
+
+// refParse is the decimal s times 2^fracBits, rounded with r.
+func refParse(s string, r Rounding) (q *big.Int, tie bool) {
+	v, _ := new(big.Rat).SetString(s)
+	v.Mul(v, new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), 64)))
+	// 64 more fractional bits than any format, and a sticky bit below them
+	p, rem := new(big.Int).DivMod(v.Num(), v.Denom(), new(big.Int))
+	exact := rem.Sign() == 0
+	p.Lsh(p, 1)
+	if !exact {
+		p.Add(p, big.NewInt(1))
+	}
+	return refShift(p, 65-fracBits, r)
+}
+
+func TestParseFixedRound(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			for _, r := range testRoundings {
+				withFormat(t, f, a, r, func() {
+					var ties int
+					check := func(s string) {
+						ResetCounters()
+						got, err := ParseFixedRound(s, r)
+						q, tie := refParse(s, r)
+						want, up, down := refFit(q)
+						if tie {
+							ties++
+						}
+						if got != want || (err == ErrOverflow) != (up || down) || err != nil && err != ErrOverflow {
+							t.Fatalf("%v %v %v: ParseFixedRound(%q) = %d, %v, want %d", f, a, r, s, got, err, want)
+						}
+						if c := ReadCounters()[opConv]; (c.Overflow == 1) != up || (c.Underflow == 1) != down {
+							t.Fatalf("%v %v %v: ParseFixedRound(%q) counted %+v", f, a, r, s, c)
+						}
+					}
+					digits := func(n int) string {
+						b := make([]byte, n)
+						for i := range b {
+							b[i] = byte('0' + rng.Intn(10))
+						}
+						return string(b)
+					}
+					sign := func() string { return [...]string{"", "-", "+"}[rng.Intn(3)] }
+					for i := 0; i < 5000; i++ {
+						check(sign() + digits(rng.Intn(f.IntBits/3+3)+1) + "." + digits(rng.Intn(40)))
+					}
+					// x and a half ulp, written out exactly
+					for i := 0; i < 1000; i++ {
+						x := new(big.Rat).SetFrac(big.NewInt(2*int64(randomFixed(rng))+1), new(big.Int).Lsh(big.NewInt(1), fracBits+1))
+						check(x.FloatString(int(fracBits) + 1))
+					}
+					for _, s := range []string{"0", "-0", ".5", "5.", "+1", "99999999999999999999999", "-99999999999999999999999.5", MIN.String(), MAX.String()} {
+						check(s)
+					}
+					if ties < 1000 {
+						t.Fatalf("%v %v %v: only %d ties", f, a, r, ties)
+					}
+				})
+			}
+		}
+	}
+	for _, s := range []string{"", "-", "+", ".", "-.", "1.2.3", "1e5", "0x10", " 1", "1,5", "--1"} {
+		if _, err := ParseFixed(s); !errors.Is(err, ErrSyntax) {
+			t.Errorf("ParseFixed(%q) gave %v, want ErrSyntax", s, err)
+		}
+	}
+}
+
+// refFloat is a times 2^fracBits, rounded with r.
+func refFloat(a float64, r Rounding) (q *big.Int, tie bool) {
+	frac, e := math.Frexp(a)
+	p := big.NewInt(int64(math.Ldexp(frac, 53)))
+	n := 53 - e - int(fracBits)
+	if n <= 0 {
+		return p.Lsh(p, uint(-n)), false
+	}
+	return refShift(p, uint(n), r)
+}
+
+func TestFloatToFixedRound(t *testing.T) {
+	rng := rand.New(rand.NewSource(14))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			for _, r := range testRoundings {
+				withFormat(t, f, a, r, func() {
+					var ties int
+					check := func(x float64) {
+						ResetCounters()
+						got, err := FloatToFixedRound(x, r)
+						q, tie := refFloat(x, r)
+						want, up, down := refFit(q)
+						if tie {
+							ties++
+						}
+						if got != want || (err == ErrOverflow) != (up || down) || err != nil && err != ErrOverflow {
+							t.Fatalf("%v %v %v: FloatToFixedRound(%v) = %d, %v, want %d", f, a, r, x, got, err, want)
+						}
+						if c := ReadCounters()[opConv]; (c.Overflow == 1) != up || (c.Underflow == 1) != down {
+							t.Fatalf("%v %v %v: FloatToFixedRound(%v) counted %+v", f, a, r, x, c)
+						}
+					}
+					for i := 0; i < 20000; i++ {
+						x := math.Ldexp(rng.Float64(), rng.Intn(f.IntBits+f.FracBits+20)-f.FracBits-10)
+						if rng.Intn(2) == 0 {
+							x = -x
+						}
+						check(x)
+					}
+					// an odd number of half ulps, when a float64 holds it
+					for i := 0; i < 2000; i++ {
+						n := 2*(randomFixed(rng)>>11) + 1
+						check(math.Ldexp(float64(n), -int(fracBits)-1))
+					}
+					for _, x := range []float64{0, math.Copysign(0, -1), 5e-324, -5e-324, 1e300, -1e300, math.MaxFloat64, toFloat(MAX), toFloat(MIN)} {
+						check(x)
+					}
+					if ties < 2000 {
+						t.Fatalf("%v %v %v: only %d ties", f, a, r, ties)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestFloatToFixedNaNInf(t *testing.T) {
+	defer ResetCounters()
+	for _, a := range []Arithmetic{Wrap, Saturate} {
+		withFormat(t, Q8_8, a, RoundHalfEven, func() {
+			ResetCounters()
+			if got, err := FloatToFixedErr(math.NaN()); got != 0 || err != ErrNaN {
+				t.Errorf("%v: NaN gave %d, %v, want 0, ErrNaN", a, got, err)
+			}
+			if c := ReadCounters(); c.Total() != 0 {
+				t.Errorf("%v: NaN counted %v", a, c)
+			}
+			for _, c := range []struct {
+				x    float64
+				want fixed
+			}{{math.Inf(1), MAX}, {math.Inf(-1), MIN}} {
+				ResetCounters()
+				got, err := FloatToFixedErr(c.x)
+				if got != c.want || err != ErrOverflow {
+					t.Errorf("%v: %v gave %d, %v, want %d, ErrOverflow", a, c.x, got, err, c.want)
+				}
+				if n := ReadCounters()[opConv]; n.Overflow+n.Underflow != 1 || (n.Overflow == 1) != (c.x > 0) {
+					t.Errorf("%v: %v counted %+v", a, c.x, n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The CORDIC routines run in Q4.60 whatever the active format is, so the
+// shift-and-add steps keep guard bits below the format's last bit, and
+// round to the active format at the end. Their tables are built once with
+// package math; evaluating them is integer only. With the default number of
+// iterations sin, cos and atan2 are within 1 ulp, tanh within 2, and sinh
+// and cosh within 10 ulp times the larger of 1 and the result.
+var cordicFormat = Format{4, 60, 64}
+
+const (
+	cordicMaxIterations = 60
+	cordicOne           = fixed(1) << 60
+	piQ60               = 0x3243F6A8885A308D
+)
+
+var (
+	// 0 means as many iterations as the active format can use
+	cordicIterations int
+
+	// atanTable[i] = atan(2^-i), atanhTable[i] = atanh(2^-i)
+	atanTable  [cordicMaxIterations]fixed
+	atanhTable [cordicMaxIterations + 1]fixed
+
+	// the starting x that cancels the gain of n iterations
+	circularGain   [cordicMaxIterations + 1]fixed
+	hyperbolicGain [cordicMaxIterations + 1]fixed
+)
+
+func init() {
+	k := 1.0
+	for i := 0; i < cordicMaxIterations; i++ {
+		circularGain[i] = cordicConst(k)
+		atanTable[i] = cordicConst(math.Atan(math.Ldexp(1, -i)))
+		k /= math.Sqrt(1 + math.Ldexp(1, -2*i))
+	}
+	circularGain[cordicMaxIterations] = cordicConst(k)
+
+	k = 1.0
+	hyperbolicGain[0] = cordicConst(k)
+	next := 4
+	for i := 1; i <= cordicMaxIterations; i++ {
+		atanhTable[i] = cordicConst(math.Atanh(math.Ldexp(1, -i)))
+		g := math.Sqrt(1 - math.Ldexp(1, -2*i))
+		k *= g
+		if i == next {
+			k *= g
+			next = 3*next + 1
+		}
+		hyperbolicGain[i] = cordicConst(1 / k)
+	}
+}
+
+func cordicConst(v float64) fixed {
+	return fixed(math.Round(math.Ldexp(v, 60)))
+}
+
+// SetCordicIterations sets how many CORDIC iterations sin, cos, atan2, sinh,
+// cosh and tanh run. Each iteration is worth about one bit; n <= 0 goes back
+// to the default of FracBits+2, enough for the active format.
+func SetCordicIterations(n int) {
+	cordicIterations = n
+}
+
+func cordicSteps() int {
+	n := cordicIterations
+	if(n <= 0){
+		n = int(fracBits) + 2
+	}
+	if(n > cordicMaxIterations){
+		n = cordicMaxIterations
+	}
+	return n
+}
+
+func toCordic(x fixed) fixed {
+	return convertFormat(x, format, cordicFormat)
+}
+
+func fromCordic(x fixed) fixed {
+	return convertFormat(x, cordicFormat, format)
+}
+
+// cordicCircular rotates (1, 0) by z, |z| <= pi/2, returning (cos z, sin z).
+// Everything is in the CORDIC format.
+func cordicCircular(z fixed, n int) (x, y fixed) {
+	x = circularGain[n]
+	for i := 0; i < n; i++ {
+		dx, dy := y>>uint(i), x>>uint(i)
+		if(z >= 0){
+			x, y, z = x - dx, y + dy, z - atanTable[i]
+		} else {
+			x, y, z = x + dx, y - dy, z + atanTable[i]
+		}
+	}
+	return
+}
+
+// cordicHyperbolic returns (cosh z, sinh z) for |z| < 1.11, repeating the
+// iterations 4, 13, 40 that the hyperbolic sequence needs to converge.
+func cordicHyperbolic(z fixed, n int) (x, y fixed) {
+	x = hyperbolicGain[n]
+	next := 4
+	for i := 1; i <= n; i++ {
+		steps := 1
+		if(i == next){
+			steps = 2
+			next = 3*next + 1
+		}
+		for ; steps > 0; steps-- {
+			dx, dy := y>>uint(i), x>>uint(i)
+			if(z >= 0){
+				x, y, z = x + dx, y + dy, z - atanhTable[i]
+			} else {
+				x, y, z = x - dx, y - dy, z + atanhTable[i]
+			}
+		}
+	}
+	return
+}
+
+// cordicDivide is y/x by linear CORDIC, for 0 < x and |y/x| < 2.
+func cordicDivide(y, x fixed, n int) (z fixed) {
+	for i := 0; i < n; i++ {
+		if(y >= 0){
+			y, z = y - x>>uint(i), z + cordicOne>>uint(i)
+		} else {
+			y, z = y + x>>uint(i), z - cordicOne>>uint(i)
+		}
+	}
+	return
+}
+
+// reduceAngle returns x mod 2*pi in [-pi, pi], in the CORDIC format. The
+// remainder comes from a 128-bit division by a 60-bit pi, so the rounding
+// of PI in the active format does not pile up for large x.
+func reduceAngle(x fixed) fixed {
+	var hi, lo uint64
+	m := magnitude(x)
+	if(fracBits <= 60){
+		s := 60 - fracBits
+		hi, lo = m>>(64-s), m<<s
+	} else {
+		lo = m >> (fracBits - 60)
+	}
+	_, rem := bits.Div64(hi, lo, 2*piQ60)
+	r := fixed(rem)
+	if(r > piQ60){
+		r -= 2*piQ60
+	}
+	if(x < 0){
+		r = -r
+	}
+	return r
+}
+
+// sincos folds x into [-pi/2, pi/2] and rotates by it.
+func sincos(x fixed) (s, c fixed) {
+	r := reduceAngle(x)
+	flip := false
+	if(r > piQ60/2){
+		r, flip = piQ60 - r, true
+	} else if(r < -piQ60/2){
+		r, flip = -piQ60 - r, true
+	}
+	cx, cy := cordicCircular(r, cordicSteps())
+	s, c = fromCordic(cy), fromCordic(cx)
+	if(flip){
+		c = -c
+	}
+	return
+}
+
+func sin(x fixed) fixed {
+	s, _ := sincos(x)
+	return s
+}
+
+func cos(x fixed) fixed {
+	_, c := sincos(x)
+	return c
+}
+
+// atan2 is the angle of the point (x, y) in (-pi, pi], by CORDIC vectoring.
+// atan2(0, 0) is 0.
+func atan2(y, x fixed) fixed {
+	if(x == 0 && y == 0){
+		return 0
+	}
+	// scale the point so its larger coordinate is just under 2^60; the angle
+	// does not change and the gain of 1.65 cannot overflow
+	m := magnitude(x)
+	if(magnitude(y) > m){
+		m = magnitude(y)
+	}
+	a, b := x, y
+	if s := 60 - bits.Len64(m); s >= 0 {
+		a, b = a<<uint(s), b<<uint(s)
+	} else {
+		a, b = a>>uint(-s), b>>uint(-s)
+	}
+	var z fixed
+	if(a < 0){
+		a, b = -a, -b
+		z = piQ60
+		if(y < 0){
+			z = -piQ60
+		}
+	}
+	for i := 0; i < cordicSteps(); i++ {
+		da, db := b>>uint(i), a>>uint(i)
+		if(b > 0){
+			a, b, z = a + da, b - db, z + atanTable[i]
+		} else {
+			a, b, z = a - da, b + db, z - atanTable[i]
+		}
+	}
+	return fromCordic(z)
+}
+
+// hyperbolicParts splits x as k*ln(2) + r and returns k together with
+// cosh r and sinh r in the CORDIC format.
+func hyperbolicParts(x fixed) (k int64, c, s fixed) {
+	k = nearestLn2Multiple(x)
+	// exact modulo 2^64, and r is small
+	r := x - fixed(k)*LN2
+	c, s = cordicHyperbolic(toCordic(r), cordicSteps())
+	return
+}
+
+// sinh and cosh combine e^x = 2^k * (cosh r + sinh r) with e^-x. The
+// smaller half is shifted into the larger in the CORDIC format, before the
+// one scaling that can overflow, so they overflow (as exp does) only when
+// the result does, and count it once.
+func sinh(x fixed) fixed {
+	k, c, s := hyperbolicParts(x)
+	if(k == 0){
+		return fromCordic(s)
+	}
+	hp, hm := (c+s)>>1, (c-s)>>1
+	if(k > 0){
+		return scale_2(fromCordic(hp - cordicShift(hm, 2*k)), k)
+	}
+	return scale_2(fromCordic(cordicShift(hp, -2*k) - hm), -k)
+}
+
+func cosh(x fixed) fixed {
+	k, c, s := hyperbolicParts(x)
+	if(k == 0){
+		return fromCordic(c)
+	}
+	hp, hm := (c+s)>>1, (c-s)>>1
+	if(k > 0){
+		return scale_2(fromCordic(hp + cordicShift(hm, 2*k)), k)
+	}
+	return scale_2(fromCordic(cordicShift(hp, -2*k) + hm), -k)
+}
+
+// cordicShift is x / 2^n, rounded down, for n >= 0.
+func cordicShift(x fixed, n int64) fixed {
+	if(n >= 63){
+		return x >> 63
+	}
+	return x >> uint(n)
+}
+
+// tanh is (1 - t)/(1 + t) with t = e^(-2|x|), divided by linear CORDIC so
+// nothing overflows however large x is.
+func tanh(x fixed) fixed {
+	ax := x
+	if(ax < 0){
+		ax = -ax
+		if(ax < 0){ // MIN of a 64-bit format
+			return -ONE
+		}
+	}
+	k, c, s := hyperbolicParts(-ax)
+	if(k < -int64(cordicMaxIterations)){
+		if(x < 0){
+			return -ONE
+		}
+		return ONE
+	}
+	u := (c + s) >> uint(-k)
+	t := mul128(u, u).shr(60, RoundHalfEven).lo
+	th := fromCordic(cordicDivide(cordicOne-fixed(t), cordicOne+fixed(t), cordicSteps()))
+	if(x < 0){
+		return -th
+	}
+	return th
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestCordicAccuracy checks sin, cos, atan2, sinh, cosh and tanh against
+// package math to the bounds in the comment at the top of cordic.go, and
+// that fewer iterations give about one bit each.
+func TestCordicAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for _, f := range testFormats {
+		for _, n := range []int{0, 12} {
+			withFormat(t, f, Saturate, RoundHalfEven, func() {
+				SetCordicIterations(n)
+				defer SetCordicIterations(0)
+				// within 16, where x converts to a float64 exactly, and the
+				// hyperbolic ones where the result fits
+				lim := math.Min(16, toFloat(MAX))
+				hlim := math.Min(lim, math.Log(toFloat(MAX)))
+				ulp := toFloat(1)
+				var worst float64
+				check := func(name string, got fixed, want, bound float64) {
+					// the float64 reference is itself rounded
+					e := math.Abs(toFloat(got) - want)
+					if n == 0 && e > bound*ulp+math.Abs(want)*0x1p-52 {
+						t.Fatalf("%v: %s = %v, want %v: error %v ulp", f, name, toFloat(got), want, e/ulp)
+					}
+					worst = math.Max(worst, e/math.Max(1, math.Abs(want)))
+				}
+				for i := 0; i < 20000; i++ {
+					x := floatToFixed((rng.Float64()*2 - 1) * lim)
+					y := floatToFixed((rng.Float64()*2 - 1) * lim)
+					h := floatToFixed((rng.Float64()*2 - 1) * hlim)
+					fx, fy, fh := toFloat(x), toFloat(y), toFloat(h)
+					check("sin", sin(x), math.Sin(fx), 1)
+					check("cos", cos(x), math.Cos(fx), 1)
+					check("atan2", atan2(y, x), math.Atan2(fy, fx), 1)
+					check("tanh", tanh(x), math.Tanh(fx), 2)
+					check("sinh", sinh(h), math.Sinh(fh), 10*math.Max(1, math.Abs(math.Sinh(fh))))
+					check("cosh", cosh(h), math.Cosh(fh), 10*math.Max(1, math.Cosh(fh)))
+				}
+				if n != 0 && f.FracBits > n+4 && (worst > math.Ldexp(1, 2-n) || worst < math.Ldexp(1, -n-4)) {
+					t.Errorf("%v: %d iterations gave a relative error of %g", f, n, worst)
+				}
+			})
+		}
+	}
+}
+
+func TestCordicEdges(t *testing.T) {
+	defer ResetCounters()
+	for _, f := range testFormats {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			for _, c := range []struct {
+				name      string
+				got, want fixed
+			}{
+				{"atan2(0, 0)", atan2(0, 0), 0},
+				{"atan2(0, -1)", atan2(0, -ONE), PI},
+				{"atan2(-1 ulp, -1)", atan2(-1, -ONE), floatToFixed(math.Atan2(-toFloat(1), -1))},
+				{"atan2(MAX, 0)", atan2(MAX, 0), HALF_PI},
+				{"atan2(MIN, MIN)", atan2(MIN, MIN), floatToFixed(-3 * math.Pi / 4)},
+				{"tanh(MAX)", tanh(MAX), ONE},
+				{"tanh(MIN)", tanh(MIN), -ONE},
+				{"sin(0)", sin(0), 0},
+				{"cos(0)", cos(0), ONE},
+			} {
+				if d := c.got - c.want; d < -1 || d > 1 {
+					t.Errorf("%v: %s = %v, want %v", f, c.name, toFloat(c.got), toFloat(c.want))
+				}
+			}
+
+			// sinh and cosh saturate like exp, and count it once
+			big := floatToFixed(math.Log(toFloat(MAX)) + 1)
+			for _, c := range []struct {
+				name string
+				fn   func() fixed
+				want fixed
+			}{
+				{"sinh", func() fixed { return sinh(big) }, MAX},
+				{"sinh(-x)", func() fixed { return sinh(-big) }, MIN},
+				{"cosh", func() fixed { return cosh(big) }, MAX},
+				{"cosh(-x)", func() fixed { return cosh(-big) }, MAX},
+			} {
+				ResetCounters()
+				got, counts := c.fn(), ReadCounters()
+				if n := counts[opExp]; got != c.want || n.Overflow+n.Underflow != 1 || counts.Total() != 1 {
+					t.Errorf("%v: %s(%v) = %v, counted %v", f, c.name, toFloat(big), toFloat(got), counts)
+				}
+			}
+		})
+	}
+}
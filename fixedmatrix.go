@@ -1,236 +1,502 @@
 package main
 import (
-	"strconv"
-	"fmt"
-	"math"
 	"bytes"
 	"errors"
+	"fmt"
 	"encoding/gob"
 	"io"
+	"math/bits"
 )
 
 var (
 	ErrShape = errors.New("mat: dimension mismatch")
+	ErrIndexOutOfRange = errors.New("mat: index out of range")
+	ErrDivideByZero = errors.New("fixed: division by zero")
+	ErrOverflow = errors.New("fixed: result out of range")
 )
 
+// ShapeError reports the dimensions an operation was given. A and B are the
+// operands, or, if Receiver is set, the receiver and the result it should
+// have held. It matches ErrShape with errors.Is.
+type ShapeError struct {
+	Op       string
+	A, B     [2]int
+	Receiver bool
+}
+
+func (e *ShapeError) Error() string {
+	if e.Receiver {
+		return fmt.Sprintf("%v in %s: receiver is %dx%d, result is %dx%d", ErrShape, e.Op, e.A[0], e.A[1], e.B[0], e.B[1])
+	}
+	return fmt.Sprintf("%v in %s: %dx%d and %dx%d", ErrShape, e.Op, e.A[0], e.A[1], e.B[0], e.B[1])
+}
+
+func (e *ShapeError) Unwrap() error {
+	return ErrShape
+}
+
+// IndexError reports an element access outside a Rows x Cols matrix. It
+// matches ErrIndexOutOfRange with errors.Is.
+type IndexError struct {
+	Op         string
+	Row, Col   int
+	Rows, Cols int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("%v in %s: (%d, %d) of %dx%d", ErrIndexOutOfRange, e.Op, e.Row, e.Col, e.Rows, e.Cols)
+}
+
+func (e *IndexError) Unwrap() error {
+	return ErrIndexOutOfRange
+}
+
 type fixed int64
 
 const maxLen = int64(int(^uint(0) >> 1))
 
+// Matrix keeps its elements in one row-major slice: element (i, j) is at
+// data[i*stride + j], or at data[j*stride + i] if trans is set. Views, lazy
+// transposes and reshapes share data with the matrix they came from.
 type Matrix struct {
 	row, col int;
-	data [][]fixed;
+	stride int;
+	trans bool;
+	data []fixed;
 }
 
+// The methods that can fail on bad dimensions or indexes come in pairs: the
+// Err version returns a *ShapeError or *IndexError, the plain one panics
+// with it.
+
 func NewMatrix(r, c int, nums []fixed) *Matrix {
-	data := make([][]fixed, r);
-	for i:=0; i<r; i++{
-		data[i] = make([]fixed, c);
-		if nums != nil {
-			for j:=0; j<c; j++{
-				data[i][j] = nums[i*c + j];
-			}
-		}
+	m, err := NewMatrixErr(r, c, nums)
+	if err != nil {
+		panic(err)
 	}
-	mat := Matrix{row: r, col: c, data: data};
-	return &mat;
+	return m
 }
 
+// NewMatrixErr fails if r or c is negative or nums, when given, does not
+// hold r*c values. The matrix uses nums, in row-major order, as its storage
+// rather than copying it; a nil nums allocates zeroed storage.
+func NewMatrixErr(r, c int, nums []fixed) (*Matrix, error) {
+	if r < 0 || c < 0 || (nums != nil && len(nums) != r*c) {
+		return nil, &ShapeError{Op: "NewMatrix", A: [2]int{r, c}, B: [2]int{len(nums), 1}}
+	}
+	if nums == nil {
+		nums = make([]fixed, r*c)
+	}
+	mat := Matrix{row: r, col: c, stride: c, data: nums};
+	return &mat, nil;
+}
+
+// Copy returns a compact row-major copy of m, whatever view m is.
 func Copy(m *Matrix) *Matrix {
 	data := make([]fixed, m.row*m.col)
 	for i:=0; i<m.row; i++{
-		for j:=0; j<m.col; j++{
-			data[i*m.col + j] = m.data[i][j]
-		}
+		m.copyRow(data[i*m.col:(i+1)*m.col], i)
 	}
 	return NewMatrix(m.row, m.col, data)
 }
 
+// copyRow copies row i of m into dst.
+func (m *Matrix) copyRow(dst []fixed, i int) {
+	if !m.trans {
+		copy(dst, m.data[i*m.stride:i*m.stride+m.col])
+		return
+	}
+	for j := range dst {
+		dst[j] = m.data[j*m.stride+i]
+	}
+}
+
 func (m *Matrix) Dims() (r,c int){
 	return m.row, m.col
 }
 
+func (m *Matrix) index(r, c int) int {
+	if m.trans {
+		return c*m.stride + r
+	}
+	return r*m.stride + c
+}
+
+// at and set skip the range check, for loops that stay inside m
+func (m *Matrix) at(r, c int) fixed {
+	return m.data[m.index(r, c)]
+}
+
+func (m *Matrix) set(r, c int, val fixed) {
+	m.data[m.index(r, c)] = val
+}
+
 func (m *Matrix) Set(r, c int, val fixed){
-	m.data[r][c] = val
+	if err := m.SetErr(r, c, val); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) SetErr(r, c int, val fixed) error {
+	if err := m.inRange("Set", r, c); err != nil {
+		return err
+	}
+	m.set(r, c, val)
+	return nil
 }
 
 func (m *Matrix) At(r, c int) fixed {
-	return m.data[r][c]
+	v, err := m.AtErr(r, c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// AtFloat is At converted exactly to float64.
+func (m *Matrix) AtFloat(r, c int) float64 {
+	return toFloat(m.At(r, c))
+}
+
+// New returns a zeroed r x c *Matrix.
+func (m *Matrix) New(r, c int) Mat {
+	return NewMatrix(r, c, nil)
+}
+
+func (m *Matrix) AtErr(r, c int) (fixed, error) {
+	if err := m.inRange("At", r, c); err != nil {
+		return 0, err
+	}
+	return m.at(r, c), nil
+}
+
+func (m *Matrix) inRange(op string, r, c int) error {
+	if r < 0 || r >= m.row || c < 0 || c >= m.col {
+		return &IndexError{Op: op, Row: r, Col: c, Rows: m.row, Cols: m.col}
+	}
+	return nil
 }
 
-func (m *Matrix) MulElem(a, b *Matrix) {
+// sameShape checks that a and b have the same dimensions and that m can
+// hold an element-wise result of them.
+func sameShape(op string, m, a, b *Matrix) error {
 	ar, ac := a.Dims()
 	br, bc := b.Dims()
 	if ar != br || ac != bc {
-		panic(ErrShape)
+		return &ShapeError{Op: op, A: [2]int{ar, ac}, B: [2]int{br, bc}}
 	}
+	return m.holds(op, ar, ac)
+}
+
+// holds checks that m is r x c, the shape of the result of op.
+func (m *Matrix) holds(op string, r, c int) error {
+	if m.row != r || m.col != c {
+		return &ShapeError{Op: op, A: [2]int{m.row, m.col}, B: [2]int{r, c}, Receiver: true}
+	}
+	return nil
+}
+
+func (m *Matrix) MulElem(a, b Mat) {
+	if err := m.MulElemErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) MulElemErr(x, y Mat) error {
+	a, b := AsMatrix(x), AsMatrix(y)
+	if err := sameShape("MulElem", m, a, b); err != nil {
+		return err
+	}
+	ar, ac := a.Dims()
 	for r := 0; r < ar; r++ {
 		for c := 0; c < ac; c++ {
-			foo := MultiplyFixed(a.At(r, c),b.At(r, c))
-			m.Set(r, c, foo)
+			foo := MultiplyFixed(a.at(r, c),b.at(r, c))
+			m.set(r, c, foo)
 		}
 	}
+	return nil
 }
 
-func (m *Matrix) Add(a, b *Matrix) {
-	ar, ac := a.Dims()
-	br, bc := b.Dims()
-	if ar != br || ac != bc {
-		panic(ErrShape)
+func (m *Matrix) Add(a, b Mat) {
+	if err := m.AddErr(a, b); err != nil {
+		panic(err)
 	}
+}
+
+func (m *Matrix) AddErr(x, y Mat) error {
+	a, b := AsMatrix(x), AsMatrix(y)
+	if err := sameShape("Add", m, a, b); err != nil {
+		return err
+	}
+	ar, ac := a.Dims()
 	for r := 0; r < ar; r++ {
 		for c := 0; c < ac; c++ {
-			foo := a.At(r, c)+b.At(r, c)
-			m.Set(r, c, foo)
+			foo := addFixed(a.at(r, c), b.at(r, c), opAdd)
+			m.set(r, c, foo)
 		}
 	}
+	return nil
 }
 
-func (m *Matrix) Sub(a, b *Matrix) {
-	ar, ac := a.Dims()
-	br, bc := b.Dims()
-	if ar != br || ac != bc {
-		panic(ErrShape)
+func (m *Matrix) Sub(a, b Mat) {
+	if err := m.SubErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) SubErr(x, y Mat) error {
+	a, b := AsMatrix(x), AsMatrix(y)
+	if err := sameShape("Sub", m, a, b); err != nil {
+		return err
 	}
+	ar, ac := a.Dims()
 	for r := 0; r < ar; r++ {
 		for c := 0; c < ac; c++ {
-			foo := a.At(r, c)-b.At(r, c)
-			m.Set(r, c, foo)
+			foo := subFixed(a.at(r, c), b.at(r, c), opSub)
+			m.set(r, c, foo)
 		}
 	}
+	return nil
 }
-// As long as a or b is not m, this works fine
-func (m *Matrix) Product(a, b *Matrix) {
-	ar, ac := a.Dims()
-	br, bc := b.Dims()
 
-	if ac != br {
-		panic(ErrShape)
+func (m *Matrix) Product(a, b Mat) {
+	if err := m.ProductErr(a, b); err != nil {
+		panic(err)
 	}
+}
 
-	for i := 0; i < ar; i++ {
-		for j := 0; j < bc; j++ {
-			var sum fixed = 0
-			for k := 0; k < ac; k++ {
-				sum += MultiplyFixed(a.At(i,k),b.At(k,j))
-			}
-			m.Set(i, j, sum)
-		}
-	}
+// As long as a or b is not m, this works fine; see gemm
+func (m *Matrix) ProductErr(a, b Mat) error {
+	return m.gemm("Product", Narrow, AsMatrix(a), false, AsMatrix(b), false)
 }
 
+// T returns the transpose of m as a view sharing m's data; use Copy to
+// materialise it.
 func (m *Matrix) T() *Matrix{
-	var newCol, newRow int = m.Dims();
-	newData := make([]fixed, newRow*newCol);
-	for i:=0; i<newRow; i++ {
-		for j:=0; j<newCol; j++{
-			newData[i*newCol + j] = m.data[j][i];
-		}
+	return &Matrix{row: m.col, col: m.row, stride: m.stride, trans: !m.trans, data: m.data}
+}
+
+func (m *Matrix) View(i, j, r, c int) *Matrix {
+	v, err := m.ViewErr(i, j, r, c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ViewErr returns the r x c sub-matrix of m whose top left element is
+// (i, j). The view shares m's data, so setting an element of one sets it in
+// the other.
+func (m *Matrix) ViewErr(i, j, r, c int) (*Matrix, error) {
+	if r < 0 || c < 0 || i < 0 || j < 0 || i+r > m.row || j+c > m.col {
+		return nil, &ShapeError{Op: "View", A: [2]int{m.row, m.col}, B: [2]int{i + r, j + c}}
+	}
+	v := &Matrix{row: r, col: c, stride: m.stride, trans: m.trans}
+	if r == 0 || c == 0 {
+		return v, nil
 	}
-	return NewMatrix(newRow,newCol,newData);
+	start := m.index(i, j)
+	end := m.index(i+r-1, j+c-1) + 1
+	v.data = m.data[start:end:end]
+	return v, nil
+}
+
+// RowView returns row i of m as a 1 x c view.
+func (m *Matrix) RowView(i int) *Matrix {
+	return m.View(i, 0, 1, m.col)
+}
+
+// ColView returns column j of m as an r x 1 view.
+func (m *Matrix) ColView(j int) *Matrix {
+	return m.View(0, j, m.row, 1)
+}
+
+func (m *Matrix) Reshape(r, c int) *Matrix {
+	v, err := m.ReshapeErr(r, c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ReshapeErr returns m, read in row-major order, as an r x c matrix. It
+// shares m's data when m is contiguous and works on a copy otherwise.
+func (m *Matrix) ReshapeErr(r, c int) (*Matrix, error) {
+	if r < 0 || c < 0 || r*c != m.row*m.col {
+		return nil, &ShapeError{Op: "Reshape", A: [2]int{m.row, m.col}, B: [2]int{r, c}}
+	}
+	src := m
+	if !m.contiguous() {
+		src = Copy(m)
+	}
+	return &Matrix{row: r, col: c, stride: c, data: src.data[:r*c]}, nil
+}
+
+// contiguous reports whether m's elements are data[:row*col] in row-major
+// order.
+func (m *Matrix) contiguous() bool {
+	if m.row <= 1 && m.col <= 1 {
+		return true
+	}
+	if m.trans {
+		return m.col == 1 || (m.row == 1 && m.stride == 1)
+	}
+	return m.stride == m.col || m.row == 1
+}
+
+// RawMatrix describes the storage of a Matrix: element (i, j) is
+// Data[i*Stride + j], or Data[j*Stride + i] when Transposed is set.
+type RawMatrix struct {
+	Rows, Cols int
+	Stride     int
+	Transposed bool
+	Data       []fixed
+}
+
+// RawMatrix returns the storage of m without copying it.
+func (m *Matrix) RawMatrix() RawMatrix {
+	return RawMatrix{m.row, m.col, m.stride, m.trans, m.data}
 }
 
 func (m *Matrix) Scale(c fixed){
 	r,col := m.Dims();
 	for i:=0; i<r; i++{
 		for j:=0; j<col; j++{
-			foo := MultiplyFixed(m.At(i,j),c)
-			m.Set(i,j,foo);
+			foo := MultiplyFixed(m.at(i,j),c)
+			m.set(i,j,foo);
 		}
 	}
 }
 
-func (m *Matrix) Apply(fn func(i, j int, v fixed) fixed, a *Matrix){
+func (m *Matrix) Apply(fn func(i, j int, v fixed) fixed, a Mat){
+	if err := m.ApplyErr(fn, a); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) ApplyErr(fn func(i, j int, v fixed) fixed, x Mat) error {
+	a := AsMatrix(x)
 	ar, ac := a.Dims();
+	if err := m.holds("Apply", ar, ac); err != nil {
+		return err
+	}
 	for r := 0; r < ar; r++ {
 		for c := 0; c < ac; c++ {
-			m.Set(r, c, fn(r, c, a.At(r, c)))
+			m.set(r, c, fn(r, c, a.at(r, c)))
 		}
 	}
+	return nil
 }
 
 func Min(m *Matrix) fixed{
-	min := m.At(0,0)
+	min, err := MinErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return min
+}
+
+// MinErr fails on an empty matrix.
+func MinErr(m *Matrix) (fixed, error){
+	min, err := m.AtErr(0, 0)
+	if err != nil {
+		return 0, err
+	}
 	for r:=0;r<m.row;r++{
 		for c:=0;c<m.col;c++{
-			if(m.At(r,c)<min){
-				min = m.At(r,c)
+			if(m.at(r,c)<min){
+				min = m.at(r,c)
 			}
 		}
 	}
-	return min
+	return min, nil
 }
 
 func Max(m *Matrix) fixed{
-	max := m.At(0,0)
+	max, err := MaxErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return max
+}
+
+// MaxErr fails on an empty matrix.
+func MaxErr(m *Matrix) (fixed, error){
+	max, err := m.AtErr(0, 0)
+	if err != nil {
+		return 0, err
+	}
 	for r:=0;r<m.row;r++{
 		for c:=0;c<m.col;c++{
-			if(m.At(r,c)>max){
-				max = m.At(r,c)
+			if(m.at(r,c)>max){
+				max = m.at(r,c)
 			}
 		}
 	}
-	return max
+	return max, nil
 }
 
-//need to account for things like negative numbers properly, fix after i optimize this to not use loops;
+// MultiplyFixed forms the full 128-bit product of a and b and rounds it back
+// to the active format using the package rounding mode.
 func MultiplyFixed(a, b fixed) fixed{
-    var isNegative bool = false;
-    if(a < 0){
-	    a = -a
-	    isNegative = !isNegative
-  	}
-    if(b < 0){
-    	b = -b
-    	isNegative = !isNegative
-  	}
-  //((A+B) * (C+D) = (A*C) + (C*B) + (A*D) + (B*D)
-
-  //A*C
-  A := a >> 48
-  C := b >> 48
-  res := (A * C) << 48
-
-  B := a & 0xffffffffffff
-  D := b & 0xffffffffffff
-
-  //C*B
-  res += (C * B)
-
-  //A*D
-  res += (A * D)
-
-  //B*D
-  res += ((B>>24) * (D>>24))
-
-  if((isNegative && res >= 0) || (!isNegative && res < 0)) {
-  	res = -res
-  }
-  return res
+	return MultiplyFixedRound(a, b, rounding)
+}
+
+func MultiplyFixedRound(a, b fixed, r Rounding) fixed{
+	return mul128(a, b).shr(fracBits, r).fixed(opMul)
 }
 
+// DivideFixed returns a/b rounded to the active format. Dividing by zero
+// gives MAX or MIN, with the sign of a, and counts as a div overflow; use
+// DivideFixedErr to find out about it.
 func DivideFixed(a, b fixed) fixed{
-  var isNegative bool = false;
-  if(a < 0){
-    a = -a
-    isNegative = !isNegative
-	}
-  if(b < 0){
-    b = -b
-    isNegative = !isNegative
-  }
-  if(b == 0){
-  	fmt.Printf("Divide By Zero Error\n")
-    return -fixed(0x1000000000000)
-  }
-  if(b == (b>>48)<<48){
-    return a / (b>>48)
-  }
-  res := (a / (b >> 24)) << 24
-  if((isNegative && res >= 0) || (!isNegative && res < 0)) {
-    res = -res
-  }
-  return res
+	q, _ := DivideFixedRound(a, b, rounding)
+	return q
+}
+
+// DivideFixedErr is DivideFixed, reporting ErrDivideByZero or ErrOverflow
+// alongside the value DivideFixed would return.
+func DivideFixedErr(a, b fixed) (fixed, error){
+	return DivideFixedRound(a, b, rounding)
+}
+
+// DivideFixedRound divides (a << fracBits) by b as a 128 by 64-bit long
+// division, so the quotient is exact before it is rounded by r. A quotient
+// that does not fit is wrapped or saturated according to the arithmetic
+// mode.
+func DivideFixedRound(a, b fixed, r Rounding) (fixed, error){
+	isNegative := (a < 0) != (b < 0)
+	if(b == 0){
+		overflowed(opDiv, a >= 0, 0)
+		if(a < 0){
+			return MIN, ErrDivideByZero
+		}
+		return MAX, ErrDivideByZero
+	}
+	if(a == 0){
+		return 0, nil
+	}
+	n, d := magnitude(a), magnitude(b)
+	hi, lo := n>>(64-fracBits), n<<fracBits
+	// a quotient of 64 bits or more cannot fit; dividing hi mod d still
+	// gives its low 64 bits, which are what Wrap keeps
+	wide := hi >= d
+	q, rem := bits.Div64(hi % d, lo, d)
+
+	// q and rem are magnitudes, so decide the rounding on the signed value
+	if(roundsUp(q, rem, d, isNegative, r)){
+		q++
+		wide = wide || q == 0
+	}
+
+	v := fixed(q)
+	if(isNegative){
+		v = -v
+	}
+	if(wide || !isNegative && q > uint64(MAX) || isNegative && q > uint64(-(MIN+1))+1){
+		return overflowed(opDiv, !isNegative, v), ErrOverflow
+	}
+	return v, nil
 }
 
 func fixedMax(a, b fixed) fixed{
@@ -255,84 +521,78 @@ func abs(x int64)int64{
 }
 
 func scale_2(x fixed, n int64)fixed{
+  if(n < 0){
+    if(n <= -63){
+      return 0
+    }
+    return x / (fixed(1) << uint64(-n))
+  }
   var i int64
-  for i = 0; i < abs(n); i++ {
-      if(n < 0) {
-        x /= 2
-      } else {
-        x *= 2
+  for i = 0; i < n; i++ {
+      if(x > MAX >> 1 || x < MIN >> 1){
+        // count it once rather than for every remaining doubling
+        return overflowed(opExp, x > 0, x << uint64(n - i))
       }
+      x *= 2
   }
   return x
 }
 
+// floor, ceil and round work on the two's complement bits: clearing the
+// fraction rounds toward minus infinity for either sign.
 func floor(x fixed)fixed{
-	xp := fixed(uint64(x) & 0xffff000000000000)
-	if(xp == x){
-		return x
-	}
-	if(x > 0){
-		return xp
-	} else {
-		return xp - 0x1000000000000
-	}
+	return x &^ fracMask
 }
 
 func ceil(x fixed)fixed{
-	xp := fixed(uint64(x) & 0xffff000000000000)
+	xp := x &^ fracMask
 	if(xp == x){
 		return x
 	}
-	if(x < 0){
-		return xp
-	} else {
-		return xp + 0x1000000000000
-	}
+	return addFixed(xp, ONE, opAdd)
 }
 
+// round rounds half way cases up, toward plus infinity.
 func round(x fixed)fixed{
-	if(fixed(uint64(x) & 0xffff800000000000) <= x){
+	if(x & fracMask >= ONE_HALF){
 		return ceil(x)
-	} else {
-		return floor(x)
 	}
+	return floor(x)
 }
 
 func bit(x fixed, n int) bool {
    return (x & (1 << (n-1))) != 0
 }
 
-const LN2 fixed = 0xB17217F7D1CF
-const LN2_H fixed = 0xB17217F7D1CE
-const LN2_L fixed = 0x1
-
-const INV_LN2 fixed = 0x171547652B82F
-
-const ONE_HALF fixed = 0x800000000000
-const ONE fixed = 0x1000000000000
-const TWO fixed = 0x2000000000000
-
-const P1 fixed = 0x2AAAAAAAAAAA
-const P2 fixed = -0x00B60B60B60B
-const P3 fixed = 0x0004559AAF00
-const P4 fixed = -0x00001BBD0000
-const P5 fixed = 0x000000B20000
+// nearestLn2Multiple is round(x / ln(2)), taken from the 128-bit product
+// with INV_LN2 so it cannot overflow in narrow formats.
+func nearestLn2Multiple(x fixed)int64{
+  q := mul128(x, INV_LN2).shr(fracBits, Truncate)
+  return int64(q.add(int128{0, uint64(ONE_HALF)}).shr(fracBits, Truncate).lo)
+}
 
+// LN2, ONE, P1..P5 and the other constants used here depend on the active
+// Format and are set up by SetFormat.
 func exp(x fixed)fixed{
   var hi fixed
   var lo fixed
   var k int64
   var t fixed = fixed(abs(int64(x)));
+  if(t < 0){
+    // -MIN does not fit in a 64-bit format; one ulp less makes no
+    // difference to the result
+    t = MAX
+  }
   if(t > LN2 / 2){ //if abs(x) > ln(2)/2
     if(t < MultiplyFixed(ONE_HALF + ONE, LN2)){
       hi = t - LN2_H
       lo = LN2_L
       k = 1
     } else {
-      k = toInt((MultiplyFixed(INV_LN2, t) + ONE_HALF))
-      k_fixed := fixed(k << 48)
-      hi = t - MultiplyFixed(k_fixed, LN2_H)
-      lo = MultiplyFixed(k_fixed, LN2_L)
+      k = nearestLn2Multiple(t)
+      // k * LN2_H is exact and hi is small, even if k itself does not fit
+      hi = t - fixed(k) * LN2_H
+      lo = fixed(k) * LN2_L
     }
     if(x < 0){
       hi = -hi
@@ -340,8 +600,8 @@ func exp(x fixed)fixed{
       k = -k
     }
     x = hi - lo
-  } else if(t < 0x10){ //if x is close to 0
-    return 0x1 << 48
+  } else if(t < fromQ48(0x10)){ //if x is close to 0
+    return ONE
   } else{
     lo = 0
     hi = 0
@@ -369,98 +629,227 @@ func exp(x fixed)fixed{
   P1_5 := floatToFixed(1.0/2) + MultiplyFixed(x, P2_5)
   return ONE + x + MultiplyFixed(x, P1_5)
 }
-
-func ln(x fixed)fixed{ //TODO
- return fixed(0) 
-}
-
-func pow(base, exponent fixed)fixed{ //TODO
-	return exp(MultiplyFixed(ln(base), exponent))
-}
-
-func log(base, val fixed)fixed{ //TODO
-  return DivideFixed(ln(val), ln(base))
-}*/
-
-func toInt(x fixed)int64{
-  isNegative := x < 0
-  x = x >> 48
-  if(isNegative){
-    x = -x 
+*/
+
+// ln follows the same reduction as exp: x = 2^k * m with sqrt(2)/2 < m <
+// sqrt(2), f = m - 1, s = f/(2+f) and
+//   ln(1+f) = f - f*f/2 + s*(f*f/2 + R(s*s))
+// where R is the Remez polynomial LG1..LG7. ln of zero or a negative number
+// returns MIN and counts a log underflow. In a format with few integer bits
+// ln of a small x can be below MIN, ln(1 ulp) of Q4.12 being -8.3; it is
+// wrapped or saturated and counted as a log underflow.
+// In Q16.48 the result is within 2e-13 of math.Log on [2^-48, 2^15); the
+// error grows to about 2^-(FracBits-4) in narrower formats.
+func ln(x fixed)fixed{
+  v, _ := lnErr(x)
+  return v
+}
+
+// lnErr is ln, reporting ErrOverflow alongside the value ln would return
+// when x is not positive or the result is below MIN.
+func lnErr(x fixed)(fixed, error){
+  if(x <= 0){
+    return overflowed(opLog, false, MIN), ErrOverflow
+  }
+  k := int64(bits.Len64(uint64(x))) - 1 - int64(fracBits)
+  m := x
+  if(k > 0){
+    m = x >> uint64(k)
+  } else {
+    m = x << uint64(-k)
+  }
+  f := m - ONE
+  if(m > SQRT2){
+    k++
+    f = (m >> 1) - ONE
   }
-  return int64(x)
+  if(f == 0 && k == 0){
+    return 0, nil
+  }
+  hfsq := MultiplyFixed(ONE_HALF, MultiplyFixed(f, f))
+  s := DivideFixed(f, TWO + f)
+  z := MultiplyFixed(s, s)
+  w := MultiplyFixed(z, z)
+  t1 := MultiplyFixed(w, LG2 + MultiplyFixed(w, LG4 + MultiplyFixed(w, LG6)))
+  t2 := MultiplyFixed(z, LG1 + MultiplyFixed(w, LG3 + MultiplyFixed(w, LG5 + MultiplyFixed(w, LG7))))
+  R := t2 + t1
+  v := fixed(k) * LN2_H - ((hfsq - (MultiplyFixed(s, hfsq + R) + fixed(k) * LN2_L)) - f)
+  if(v < MIN || v > MAX){
+    return overflowed(opLog, v > MAX, v), ErrOverflow
+  }
+  return v, nil
+}
+
+// pow raises base to exponent. Integral exponents are done by repeated
+// squaring, so they are exact up to the rounding of each product and work
+// for negative bases; otherwise pow is exp(exponent * ln(base)) and a
+// negative base gives MIN and counts a log underflow, like ln does.
+// For Q16.48 and results in [2^-10, 2^15) the relative error against
+// math.Pow is under 1e-11 for fractional exponents; smaller results are
+// only a few ulp and within 2e-13 absolute.
+func pow(base, exponent fixed)fixed{
+  if(exponent == floor(exponent)){
+    n := exponent >> fracBits
+    if(n < 0){
+      return DivideFixed(ONE, powInt(base, -int64(n)))
+    }
+    return powInt(base, int64(n))
+  }
+  if(base <= 0){
+    if(base < 0){
+      return overflowed(opLog, false, MIN)
+    }
+    if(exponent > 0){
+      return 0
+    }
+    return overflowed(opExp, true, MAX)
+  }
+  return exp(MultiplyFixed(ln(base), exponent))
 }
 
-func intToFixed(x int) fixed{
-	isNegative := x < 0
-  x = x << 48
-  if(isNegative){
-    x = -x 
+func powInt(base fixed, n int64)fixed{
+  res := ONE
+  for n > 0 {
+    if(n & 1 == 1){
+      res = MultiplyFixed(res, base)
+    }
+    n >>= 1
+    if(n > 0){
+      base = MultiplyFixed(base, base)
+    }
   }
-	return fixed(x);
+  return res
 }
 
-func floatToFixed(a float64) fixed{
-	isNegative := a < 0
-	if(isNegative){
-		a = -a
-	}
-	i := int64(math.Float64bits(a))
-	mantissa := (i & 0xfffffffffffff)+0x10000000000000
-	exponent := (i>>52)-1023
-	output := mantissa>>4
-	if(exponent < 0){
-		exponent = -exponent
-		output = output>>exponent
-	} else {
-		output = output<<exponent
-	}
-	if(isNegative){
-		output = -output
-	}
-	return fixed(output)
+// logN is the logarithm of val in base N, ln(val)/ln(N). (It is not called
+// log because main imports package log.)
+func logN(base, val fixed)fixed{
+  return DivideFixed(ln(val), ln(base))
 }
 
-func toFloat(a fixed) float64{
-	var out float64
-	out = 1.0
-	return (out * float64(int64(a)))/0x1000000000000
+// sqrt takes the integer square root of x << fracBits, so it only uses
+// integer operations and is correctly rounded: within 1/2 ulp, or below
+// by less than 1 ulp when the rounding mode is Truncate. sqrt(0) is 0; a
+// negative x returns 0, whatever the arithmetic mode, and counts a sqrt
+// underflow.
+func sqrt(x fixed)fixed{
+  if(x <= 0){
+    if(x < 0){
+      overflowed(opSqrt, false, 0)
+    }
+    return 0
+  }
+  n := uint64(x)
+  return fixed(sqrt128(n>>(64-fracBits), n<<fracBits, rounding))
+}
+
+// rsqrt is 1/sqrt(x), divided out of the correctly rounded sqrt. For x >= 1
+// it is within 1 ulp; below 1 the error of sqrt is magnified and the bound
+// is about 1/2 + 1/(2x) ulp, or 1/x ulp when the rounding mode is
+// Truncate, a little more when sqrt(x) is only a few hundred ulps.
+// rsqrt(0) overflows to MAX; a negative x returns 0 and counts a sqrt
+// underflow.
+func rsqrt(x fixed)fixed{
+  if(x < 0){
+    overflowed(opSqrt, false, 0)
+    return 0
+  }
+  return DivideFixed(ONE, sqrt(x))
+}
+
+// rsqrtN is 1/sqrt(n) for a positive integer n that need not fit the
+// active format, such as a layer's fan-in. It is the square root of
+// 2^(2*fracBits) / n and within 1 ulp. Like rsqrt, rsqrtN(0) overflows to
+// MAX and a negative n returns 0 and counts a sqrt underflow.
+func rsqrtN(n int)fixed{
+  if(n <= 0){
+    if(n < 0){
+      overflowed(opSqrt, false, 0)
+      return 0
+    }
+    return overflowed(opSqrt, true, MAX)
+  }
+  d := uint64(n)
+  hi, lo := uint64(1)<<(2*fracBits-64), uint64(0)
+  if(2*fracBits < 64){
+    hi, lo = 0, uint64(1)<<(2*fracBits)
+  }
+  qhi, r := hi/d, hi%d
+  qlo, _ := bits.Div64(r, lo, d)
+  if(qhi != 0 && bits.Len64(qhi) > 62){
+    return overflowed(opSqrt, true, MAX)
+  }
+  return fit(fixed(sqrt128(qhi, qlo, rounding)), opSqrt)
 }
 
-func (f fixed) String() string {
-	s := ""
-	if(f<0){
-		s += "-"
-		f = -f
-	}
-	b := f & 0xffffffffffff
-	s += fmt.Sprintf("%d", f >> 48)
-	s += strconv.FormatFloat(toFloat(b), 'f', -1, 64)[1:]
-  return s 
+// sqrt128 is the square root of the unsigned 128-bit hi:lo, which must be
+// below 2^126, by integer Newton iteration. Any mode but Truncate rounds to
+// nearest; a square root of an integer is never exactly halfway.
+func sqrt128(hi, lo uint64, r Rounding)uint64{
+  if(hi == 0 && lo < 2){
+    return lo
+  }
+  n := bits.Len64(lo)
+  if(hi != 0){
+    n = 64 + bits.Len64(hi)
+  }
+  // start above the root and come down; x >= sqrt(hi:lo) >= hi keeps the
+  // division in range
+  x := uint64(1) << uint((n+1)/2)
+  for {
+    q, _ := bits.Div64(hi, lo, x)
+    y := x>>1 + q>>1 + (x & q & 1)
+    if(y >= x){
+      break
+    }
+    x = y
+  }
+  if(r != Truncate){
+    // round up when hi:lo > x*x + x, i.e. at or above (x + 1/2)^2
+    sh, sl := bits.Mul64(x, x+1)
+    if(hi > sh || (hi == sh && lo > sl)){
+      x++
+    }
+  }
+  return x
 }
 
+// Resize changes m to r x c, keeping the elements that are in both shapes
+// and zeroing the rest. m gets storage of its own, even if it was a view.
 func (m *Matrix) Resize(r, c int){
-	data := make([][]fixed, r);
+	data := make([]fixed, r*c);
 	for i:=0; i<r; i++{
-		data[i] = make([]fixed, c);
 		for j:=0; j<c; j++{
 			if((i<m.row) && (j < m.col)){
-				data[i][j] = m.data[i][j];
-			} else {
-				data[i][j] = fixed(0)
+				data[i*c + j] = m.at(i, j);
 			}
 		}
 	}
+	m.row = r
+	m.col = c
+	m.stride = c
+	m.trans = false
 	m.data = data
 }
 
+// Frac records the fractional bits the data was saved with; models written
+// before formats were configurable leave it zero and are Q16.48. Data is
+// kept as one slice per row so models saved before Matrix stored a single
+// slice still load.
 type wrapMatrix struct {
 	Row, Col int;
 	Data [][]fixed;
+	Frac int;
+	Int int;
 }
 
 func (m *Matrix) MarshalBinaryTo() (io.Reader, error) {
-  w := wrapMatrix{m.row, m.col, m.data}
+  data := make([][]fixed, m.row)
+  for i := range data {
+    data[i] = make([]fixed, m.col)
+    m.copyRow(data[i], i)
+  }
+  w := wrapMatrix{m.row, m.col, data, format.FracBits, format.IntBits}
   var buf bytes.Buffer
   enc := gob.NewEncoder(&buf)
   if err := enc.Encode(w); err != nil {
@@ -475,8 +864,31 @@ func (m *Matrix) UnmarshalBinaryFrom(reader io.Reader) error {
   if err := dec.Decode(&w); err != nil {
     return err
   }
+  from := Q16_48
+  if w.Frac != 0 {
+    from = Format{w.Int, w.Frac, 64}
+  }
+  if from.FracBits != format.FracBits || from.IntBits != format.IntBits {
+    for _, row := range w.Data {
+      for j := range row {
+        row[j] = convertFormat(row[j], from, format)
+      }
+    }
+  }
+  if len(w.Data) != w.Row {
+    return &ShapeError{Op: "UnmarshalBinaryFrom", A: [2]int{w.Row, w.Col}, B: [2]int{len(w.Data), w.Col}}
+  }
+  data := make([]fixed, w.Row*w.Col)
+  for i, row := range w.Data {
+    if len(row) != w.Col {
+      return &ShapeError{Op: "UnmarshalBinaryFrom", A: [2]int{w.Row, w.Col}, B: [2]int{len(w.Data), len(row)}}
+    }
+    copy(data[i*w.Col:], row)
+  }
   m.row = w.Row
   m.col = w.Col
-  m.data = w.Data
+  m.stride = w.Col
+  m.trans = false
+  m.data = data
   return nil
 }
\ No newline at end of file
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// TestLnAccuracy checks the bounds in the doc of ln, with x spread evenly
+// over the powers of two of the format, and that results below MIN are
+// saturated and counted.
+func TestLnAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, f := range []Format{Q16_48, Q8_24, Q4_12, {3, 13, 16}, {3, 29, 32}} {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			bound := math.Ldexp(1, 4-f.FracBits)
+			if f == Q16_48 {
+				bound = 2e-13
+			}
+			for i := 0; i < 100000; i++ {
+				x := fixed(math.Exp2(rng.Float64() * float64(f.Bits()-1)))
+				if x <= 0 || x > MAX {
+					continue
+				}
+				ResetCounters()
+				got, want := ln(x), math.Log(toFloat(x))
+				if want < toFloat(MIN) {
+					if got != MIN || ReadCounters()[opLog].Underflow != 1 {
+						t.Fatalf("%v: ln(%v) = %v, want MIN and a log underflow", f, toFloat(x), toFloat(got))
+					}
+					continue
+				}
+				if e := math.Abs(toFloat(got) - want); e > bound {
+					t.Fatalf("%v: ln(%v) = %v, want %v: error %g", f, toFloat(x), toFloat(got), want, e)
+				}
+			}
+		})
+	}
+}
+
+// TestPowAccuracy checks the bounds in the doc of pow for Q16.48 and
+// fractional exponents.
+func TestPowAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	var n int
+	for i := 0; i < 200000; i++ {
+		b := floatToFixed(math.Exp2(rng.Float64()*40 - 20))
+		y := floatToFixed(rng.Float64()*8 - 4)
+		want := math.Pow(toFloat(b), toFloat(y))
+		if y == floor(y) || want >= 0x1p15 {
+			continue
+		}
+		got := toFloat(pow(b, y))
+		if want >= 0x1p-10 {
+			n++
+			if r := math.Abs(got-want) / want; r >= 1e-11 {
+				t.Fatalf("pow(%v, %v) = %v, want %v: relative error %g", toFloat(b), toFloat(y), got, want, r)
+			}
+		} else if e := math.Abs(got - want); e > 2e-13 {
+			t.Fatalf("pow(%v, %v) = %v, want %v: error %g", toFloat(b), toFloat(y), got, want, e)
+		}
+	}
+	if n < 50000 {
+		t.Fatalf("only %d results in [2^-10, 2^15)", n)
+	}
+}
+
+func TestPowNegativeBase(t *testing.T) {
+	defer ResetCounters()
+	cases := []struct {
+		base, exponent, want fixed
+	}{
+		{-2 * ONE, 3 * ONE, -8 * ONE},
+		{-2 * ONE, -2 * ONE, ONE >> 2},
+		{-2 * ONE, ONE_HALF, MIN},
+		{-2 * ONE, -ONE_HALF, MIN},
+		{-1, ONE + ONE_HALF, MIN},
+	}
+	for _, c := range cases {
+		ResetCounters()
+		if got := pow(c.base, c.exponent); got != c.want {
+			t.Errorf("pow(%v, %v) = %v, want %v", toFloat(c.base), toFloat(c.exponent), toFloat(got), toFloat(c.want))
+		}
+		if under := ReadCounters()[opLog].Underflow; (c.want == MIN) != (under == 1) {
+			t.Errorf("pow(%v, %v) counted %d log underflows", toFloat(c.base), toFloat(c.exponent), under)
+		}
+	}
+}
+
+// refQuotient is (a << fracBits) / b as a big.Int, rounded with r like
+// refProduct.
+func refQuotient(a, b fixed, r Rounding) (q *big.Int, tie bool) {
+	num := new(big.Int).Lsh(big.NewInt(int64(a)), fracBits)
+	den := big.NewInt(int64(b))
+	if den.Sign() < 0 {
+		num.Neg(num)
+		den.Neg(den)
+	}
+	q, rem := new(big.Int).DivMod(num, den, new(big.Int))
+	c := new(big.Int).Lsh(rem, 1).Cmp(den)
+	switch {
+	case r == RoundHalfUp && c >= 0,
+		r == RoundHalfEven && (c > 0 || c == 0 && q.Bit(0) == 1):
+		q.Add(q, big.NewInt(1))
+	}
+	return q, c == 0
+}
+
+func TestDivideFixedRound(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			for _, r := range testRoundings {
+				withFormat(t, f, a, r, func() {
+					var ties, overflows int
+					check := func(x, y fixed) {
+						if y == 0 {
+							return
+						}
+						ResetCounters()
+						got, err := DivideFixedRound(x, y, r)
+						q, tie := refQuotient(x, y, r)
+						want, up, down := refFit(q)
+						if tie {
+							ties++
+						}
+						if got != want {
+							t.Fatalf("%v %v %v: %d / %d = %d, want %d", f, a, r, x, y, got, want)
+						}
+						if (err == ErrOverflow) != (up || down) || err != nil && err != ErrOverflow {
+							t.Fatalf("%v %v %v: %d / %d gave error %v", f, a, r, x, y, err)
+						}
+						if up || down {
+							overflows++
+						}
+						c := ReadCounters()[opDiv]
+						if (c.Overflow == 1) != up || (c.Underflow == 1) != down {
+							t.Fatalf("%v %v %v: %d / %d counted %+v, want up %v down %v", f, a, r, x, y, c, up, down)
+						}
+					}
+					for _, x := range edges() {
+						for _, y := range edges() {
+							check(x, y)
+						}
+					}
+					for i := 0; i < 20000; i++ {
+						check(randomFixed(rng), randomFixed(rng))
+					}
+					// an odd x over +-2 drops exactly one half
+					for i := 0; i < 2000; i++ {
+						check(randomFixed(rng)|1, TWO*fixed(1-2*rng.Intn(2)))
+					}
+					if ties < 2000 || overflows < 1000 {
+						t.Fatalf("%v %v %v: only %d ties and %d overflows", f, a, r, ties, overflows)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDivideFixedOverflow(t *testing.T) {
+	for _, f := range testFormats {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			// the wrapped quotient keeps its sign bits: -MAX / 1 ulp is
+			// -MAX << fracBits, not the shifted numerator
+			want := wrap(-MAX << fracBits)
+			if got, err := DivideFixedRound(-MAX, 1, RoundHalfEven); got != want || err != ErrOverflow {
+				t.Errorf("%v wrap: -MAX / 1 ulp = %d, %v, want %d", f, got, err, want)
+			}
+			if got, err := DivideFixedRound(MIN, -ONE, RoundHalfEven); got != MIN || err != ErrOverflow {
+				t.Errorf("%v wrap: MIN / -1 = %d, %v, want MIN", f, got, err)
+			}
+		})
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			cases := []struct {
+				a, b, want fixed
+			}{
+				{MAX, 1, MAX},
+				{-MAX, 1, MIN},
+				{MAX, -1, MIN},
+				{MIN, -ONE, MAX},
+				{MIN, ONE, MIN},
+			}
+			for _, c := range cases {
+				if got, err := DivideFixedRound(c.a, c.b, RoundHalfEven); got != c.want || (err == nil) != (c.want != MAX && c.want != MIN || c.a == MIN && c.b == ONE) {
+					t.Errorf("%v saturate: %d / %d = %d, %v, want %d", f, c.a, c.b, got, err, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDivideByZero(t *testing.T) {
+	defer ResetCounters()
+	for _, a := range []Arithmetic{Wrap, Saturate} {
+		withFormat(t, Q16_48, a, RoundHalfEven, func() {
+			for _, c := range []struct{ a, want fixed }{{ONE, MAX}, {0, MAX}, {-ONE, MIN}} {
+				ResetCounters()
+				got, err := DivideFixedErr(c.a, 0)
+				if got != c.want || err != ErrDivideByZero {
+					t.Errorf("%v: %d / 0 = %d, %v, want %d, ErrDivideByZero", a, c.a, got, err, c.want)
+				}
+				if n := ReadCounters()[opDiv]; n.Overflow+n.Underflow != 1 {
+					t.Errorf("%v: %d / 0 counted %+v", a, c.a, n)
+				}
+			}
+		})
+	}
+}
+
+// TestExpMin checks exp, and the activations built on it, at MIN, the
+// value a saturated pre-activation takes.
+func TestExpMin(t *testing.T) {
+	for _, f := range append(testFormats, Format{3, 61, 64}) {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			ulp := toFloat(1)
+			e := math.Exp(toFloat(MIN))
+			if got := toFloat(exp(MIN)); math.Abs(got-e) > 1e-12+4*ulp {
+				t.Errorf("%v: exp(MIN) = %v, want %v", f, got, e)
+			}
+			if got, want := toFloat(elu(0, 0, MIN)), math.Expm1(toFloat(MIN)); math.Abs(got-want) > 1e-12+4*ulp {
+				t.Errorf("%v: elu(MIN) = %v, want %v", f, got, want)
+			}
+			if got, want := toFloat(softplus(0, 0, MIN)), math.Log1p(e); math.Abs(got-want) > 1e-12+4*ulp {
+				t.Errorf("%v: softplus(MIN) = %v, want %v", f, got, want)
+			}
+		})
+	}
+}
+
+// recovered returns what fn panicked with, or nil.
+func recovered(fn func()) (v interface{}) {
+	defer func() { v = recover() }()
+	fn()
+	return nil
+}
+
+// TestMatrixErrors checks that the Err methods return a *ShapeError or an
+// *IndexError where their plain versions panic with the same error.
+func TestMatrixErrors(t *testing.T) {
+	m23 := NewMatrix(2, 3, nil)
+	m22 := NewMatrix(2, 2, nil)
+	empty := NewMatrix(0, 0, nil)
+	cases := []struct {
+		name  string
+		err   func() error
+		plain func()
+		index bool
+	}{
+		{"NewMatrix short", func() error { _, err := NewMatrixErr(2, 2, make([]fixed, 3)); return err },
+			func() { NewMatrix(2, 2, make([]fixed, 3)) }, false},
+		{"NewMatrix negative", func() error { _, err := NewMatrixErr(-1, 2, nil); return err },
+			func() { NewMatrix(-1, 2, nil) }, false},
+		{"At", func() error { _, err := m23.AtErr(2, 0); return err }, func() { m23.At(2, 0) }, true},
+		{"At transposed", func() error { _, err := m23.T().AtErr(0, 2); return err }, func() { m23.T().At(0, 2) }, true},
+		{"Set", func() error { return m23.SetErr(0, -1, ONE) }, func() { m23.Set(0, -1, ONE) }, true},
+		{"MulElem", func() error { return m22.MulElemErr(m22, m23) }, func() { m22.MulElem(m22, m23) }, false},
+		{"Add", func() error { return m22.AddErr(m23, m23) }, func() { m22.Add(m23, m23) }, false},
+		{"Sub", func() error { return m23.SubErr(m22, m23) }, func() { m23.Sub(m22, m23) }, false},
+		{"Product", func() error { return m22.ProductErr(m23, m23) }, func() { m22.Product(m23, m23) }, false},
+		{"ProductTransA", func() error { return m22.ProductTransAErr(m23, m22) }, func() { m22.ProductTransA(m23, m22) }, false},
+		{"ProductTransB", func() error { return m22.ProductTransBErr(m23, m22) }, func() { m22.ProductTransB(m23, m22) }, false},
+		{"View", func() error { _, err := m23.ViewErr(1, 1, 2, 2); return err }, func() { m23.View(1, 1, 2, 2) }, false},
+		{"Reshape", func() error { _, err := m23.ReshapeErr(4, 2); return err }, func() { m23.Reshape(4, 2) }, false},
+		{"Apply", func() error { return m22.ApplyErr(func(i, j int, v fixed) fixed { return v }, m23) },
+			func() { m22.Apply(func(i, j int, v fixed) fixed { return v }, m23) }, false},
+		{"Min", func() error { _, err := MinErr(empty); return err }, func() { Min(empty) }, true},
+		{"Max", func() error { _, err := MaxErr(empty); return err }, func() { Max(empty) }, true},
+	}
+	for _, c := range cases {
+		err := c.err()
+		var se *ShapeError
+		var ie *IndexError
+		switch {
+		case c.index && (!errors.As(err, &ie) || !errors.Is(err, ErrIndexOutOfRange)):
+			t.Errorf("%s gave %v, want an *IndexError", c.name, err)
+		case !c.index && (!errors.As(err, &se) || !errors.Is(err, ErrShape)):
+			t.Errorf("%s gave %v, want a *ShapeError", c.name, err)
+		}
+		if p := recovered(c.plain); p == nil || p.(error).Error() != err.Error() {
+			t.Errorf("%s panicked with %v, want %v", c.name, p, err)
+		}
+	}
+
+	var se *ShapeError
+	if err := m22.AddErr(m23, m23); !errors.As(err, &se) || !se.Receiver || se.A != [2]int{2, 2} || se.B != [2]int{2, 3} {
+		t.Errorf("Add of 2x3 into 2x2 gave %#v, want the receiver and the result shapes", se)
+	}
+	var ie *IndexError
+	if _, err := m23.AtErr(2, 0); !errors.As(err, &ie) || *ie != (IndexError{Op: "At", Row: 2, Col: 0, Rows: 2, Cols: 3}) {
+		t.Errorf("At(2, 0) of 2x3 gave %#v", ie)
+	}
+}
+
+// TestViewWrites checks that writes through views, transposed views and
+// reshapes land in the matrix they share data with, and that Copy and a
+// reshape of a view that is not contiguous do not.
+func TestViewWrites(t *testing.T) {
+	m := NewMatrix(4, 5, nil)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5; j++ {
+			m.Set(i, j, fixed(10*i+j))
+		}
+	}
+	writes := []struct {
+		name string
+		v    *Matrix
+		i, j int // where (0, 1) of v is in m
+	}{
+		{"View", m.View(1, 2, 2, 3), 1, 3},
+		{"T", m.T(), 1, 0},
+		{"View of T", m.T().View(2, 1, 3, 2), 2, 2},
+		{"T of View", m.View(1, 1, 3, 3).T(), 2, 1},
+		{"View of View", m.View(1, 1, 3, 4).View(1, 0, 2, 3), 2, 2},
+		{"RowView", m.RowView(3), 3, 1},
+		{"Reshape", m.Reshape(10, 2), 0, 1},
+		{"Reshape of a row", m.View(2, 0, 1, 5).Reshape(5, 1).T(), 2, 1},
+	}
+	for _, w := range writes {
+		want := fixed(1000) + m.At(w.i, w.j)
+		w.v.Set(0, 1, want)
+		if got := m.At(w.i, w.j); got != want {
+			t.Errorf("%s: m(%d, %d) = %d after the write, want %d", w.name, w.i, w.j, got, want)
+		}
+		if got := w.v.At(0, 1); got != want {
+			t.Errorf("%s: read back %d, want %d", w.name, got, want)
+		}
+	}
+
+	// an operation into a view only writes inside it
+	before := Copy(m)
+	v := m.View(1, 1, 2, 2)
+	v.Add(NewMatrix(2, 2, []fixed{1, 1, 1, 1}), NewMatrix(2, 2, []fixed{1, 2, 3, 4}))
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5; j++ {
+			want := before.At(i, j)
+			if i >= 1 && i < 3 && j >= 1 && j < 3 {
+				want = fixed(2 + 2*(i-1) + j - 1)
+			}
+			if got := m.At(i, j); got != want {
+				t.Errorf("Add into a view: m(%d, %d) = %d, want %d", i, j, got, want)
+			}
+		}
+	}
+
+	for name, c := range map[string]*Matrix{
+		"Copy":                Copy(m),
+		"Reshape of a view":   m.View(0, 0, 2, 2).Reshape(1, 4),
+		"Reshape of T of 4x5": m.T().Reshape(4, 5),
+	} {
+		old := m.At(0, 1)
+		c.Set(0, 1, old+1)
+		if m.At(0, 1) != old {
+			t.Errorf("%s shares data with m", name)
+		}
+	}
+
+	// a reshape reads in row-major order whatever the view
+	r := m.View(1, 1, 2, 2).T().Reshape(4, 1)
+	for k, want := range []fixed{m.At(1, 1), m.At(2, 1), m.At(1, 2), m.At(2, 2)} {
+		if got := r.At(k, 0); got != want {
+			t.Errorf("Reshape of a transposed view: element %d = %d, want %d", k, got, want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+// FloatMatrix is a row-major float64 matrix, the reference *Matrix is
+// measured against. Its arithmetic is plain float64, with no format,
+// rounding mode or overflow counting; the Accumulator is ignored, and the
+// sigmoid is the exact logistic function whatever the Squash.
+type FloatMatrix struct {
+	row, col int
+	data     []float64
+}
+
+func NewFloatMatrix(r, c int, data []float64) *FloatMatrix {
+	m, err := NewFloatMatrixErr(r, c, data)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// NewFloatMatrixErr is NewMatrixErr for float64 data, which it likewise
+// adopts as its storage.
+func NewFloatMatrixErr(r, c int, data []float64) (*FloatMatrix, error) {
+	if r < 0 || c < 0 || (data != nil && len(data) != r*c) {
+		return nil, &ShapeError{Op: "NewFloatMatrix", A: [2]int{r, c}, B: [2]int{len(data), 1}}
+	}
+	if data == nil {
+		data = make([]float64, r*c)
+	}
+	return &FloatMatrix{row: r, col: c, data: data}, nil
+}
+
+func (m *FloatMatrix) Dims() (r, c int) {
+	return m.row, m.col
+}
+
+func (m *FloatMatrix) inRange(op string, r, c int) error {
+	if r < 0 || r >= m.row || c < 0 || c >= m.col {
+		return &IndexError{Op: op, Row: r, Col: c, Rows: m.row, Cols: m.col}
+	}
+	return nil
+}
+
+func (m *FloatMatrix) holds(op string, r, c int) error {
+	if m.row != r || m.col != c {
+		return &ShapeError{Op: op, A: [2]int{m.row, m.col}, B: [2]int{r, c}, Receiver: true}
+	}
+	return nil
+}
+
+// At rounds element (r, c) to the active format.
+func (m *FloatMatrix) At(r, c int) fixed {
+	return floatToFixed(m.AtFloat(r, c))
+}
+
+func (m *FloatMatrix) Set(r, c int, v fixed) {
+	m.SetFloat(r, c, toFloat(v))
+}
+
+func (m *FloatMatrix) AtFloat(r, c int) float64 {
+	if err := m.inRange("At", r, c); err != nil {
+		panic(err)
+	}
+	return m.data[r*m.col+c]
+}
+
+func (m *FloatMatrix) SetFloat(r, c int, v float64) {
+	if err := m.inRange("Set", r, c); err != nil {
+		panic(err)
+	}
+	m.data[r*m.col+c] = v
+}
+
+func (m *FloatMatrix) New(r, c int) Mat {
+	return NewFloatMatrix(r, c, nil)
+}
+
+// RawData returns the storage of m without copying it.
+func (m *FloatMatrix) RawData() []float64 {
+	return m.data
+}
+
+func (a *FloatMatrix) ProductInto(dst Mat, acc Accumulator, b Mat) error {
+	return dst.ProductWith(acc, a, false, b, false)
+}
+
+func (m *FloatMatrix) ProductErr(a, b Mat) error {
+	return m.ProductWith(Narrow, a, false, b, false)
+}
+
+// ProductWith sets m to op(a)·op(b) as ProductWith on *Matrix does; acc
+// makes no difference in float64.
+func (m *FloatMatrix) ProductWith(acc Accumulator, x Mat, ta bool, y Mat, tb bool) error {
+	a, b := AsFloatMatrix(x), AsFloatMatrix(y)
+	ar, ac := a.Dims()
+	if ta {
+		ar, ac = ac, ar
+	}
+	br, bc := b.Dims()
+	if tb {
+		br, bc = bc, br
+	}
+	if ac != br {
+		return &ShapeError{Op: "ProductWith", A: [2]int{ar, ac}, B: [2]int{br, bc}}
+	}
+	if err := m.holds("ProductWith", ar, bc); err != nil {
+		return err
+	}
+	op := func(m *FloatMatrix, t bool, i, j int) float64 {
+		if t {
+			return m.data[j*m.col+i]
+		}
+		return m.data[i*m.col+j]
+	}
+	data := make([]float64, ar*bc)
+	for i := 0; i < ar; i++ {
+		for j := 0; j < bc; j++ {
+			var sum float64
+			for k := 0; k < ac; k++ {
+				sum += op(a, ta, i, k) * op(b, tb, k, j)
+			}
+			data[i*bc+j] = sum
+		}
+	}
+	copy(m.data, data)
+	return nil
+}
+
+func (m *FloatMatrix) MulElemErr(a, b Mat) error {
+	return m.elementwise("MulElem", func(x, y float64) float64 { return x * y }, a, b)
+}
+
+func (m *FloatMatrix) AddErr(a, b Mat) error {
+	return m.elementwise("Add", func(x, y float64) float64 { return x + y }, a, b)
+}
+
+func (m *FloatMatrix) SubErr(a, b Mat) error {
+	return m.elementwise("Sub", func(x, y float64) float64 { return x - y }, a, b)
+}
+
+func (m *FloatMatrix) elementwise(op string, fn func(x, y float64) float64, x, y Mat) error {
+	a, b := AsFloatMatrix(x), AsFloatMatrix(y)
+	if a.row != b.row || a.col != b.col {
+		return &ShapeError{Op: op, A: [2]int{a.row, a.col}, B: [2]int{b.row, b.col}}
+	}
+	if err := m.holds(op, a.row, a.col); err != nil {
+		return err
+	}
+	for i := range m.data {
+		m.data[i] = fn(a.data[i], b.data[i])
+	}
+	return nil
+}
+
+// AddBroadcastErr is AddBroadcastErr on *Matrix in float64.
+func (m *FloatMatrix) AddBroadcastErr(a, b Mat) error {
+	return m.broadcast("AddBroadcast", func(x, y float64) float64 { return x + y }, a, b)
+}
+
+// SubBroadcastErr is SubBroadcastErr on *Matrix in float64.
+func (m *FloatMatrix) SubBroadcastErr(a, b Mat) error {
+	return m.broadcast("SubBroadcast", func(x, y float64) float64 { return x - y }, a, b)
+}
+
+// SumAlongErr is SumAlongErr on *Matrix in float64.
+func (m *FloatMatrix) SumAlongErr(x Mat, axis Axis) error {
+	a := AsFloatMatrix(x)
+	r, c := a.row, 1
+	if axis == AlongCols {
+		r, c = 1, a.col
+	}
+	if err := m.holds("SumAlong", r, c); err != nil {
+		return err
+	}
+	for k := range m.data {
+		m.data[k] = 0
+	}
+	for i := 0; i < a.row; i++ {
+		for j := 0; j < a.col; j++ {
+			k := i
+			if axis == AlongCols {
+				k = j
+			}
+			m.data[k] += a.data[i*a.col+j]
+		}
+	}
+	return nil
+}
+
+func (m *FloatMatrix) broadcast(op string, fn func(x, y float64) float64, x, y Mat) error {
+	a, b := AsFloatMatrix(x), AsFloatMatrix(y)
+	r, ok := broadcastDim(a.row, b.row)
+	c, ok2 := broadcastDim(a.col, b.col)
+	if !ok || !ok2 {
+		return &ShapeError{Op: op, A: [2]int{a.row, a.col}, B: [2]int{b.row, b.col}}
+	}
+	if err := m.holds(op, r, c); err != nil {
+		return err
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.data[i*c+j] = fn(a.data[(i%a.row)*a.col+j%a.col], b.data[(i%b.row)*b.col+j%b.col])
+		}
+	}
+	return nil
+}
+
+// ApplyErr runs fn on every element of a rounded to the active format, so
+// it is only as exact as fn.
+func (m *FloatMatrix) ApplyErr(fn func(i, j int, v fixed) fixed, x Mat) error {
+	a := AsFloatMatrix(x)
+	if err := m.holds("Apply", a.row, a.col); err != nil {
+		return err
+	}
+	for i := 0; i < a.row; i++ {
+		for j := 0; j < a.col; j++ {
+			m.data[i*a.col+j] = toFloat(fn(i, j, floatToFixed(a.data[i*a.col+j])))
+		}
+	}
+	return nil
+}
+
+func (m *FloatMatrix) Scale(c fixed) {
+	f := toFloat(c)
+	for i := range m.data {
+		m.data[i] *= f
+	}
+}
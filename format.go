@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrFormat = errors.New("fixed: unsupported format")
+)
+
+// Format describes a signed two's complement Q-format. IntBits counts the
+// integer bits including the sign, FracBits the fractional bits, and Width
+// the storage word the value lives in on the target (8, 16, 32 or 64 bits).
+// Values are always held in an int64 and wrapped to IntBits+FracBits bits.
+type Format struct {
+	IntBits  int
+	FracBits int
+	Width    int
+}
+
+var (
+	Q16_48 = Format{16, 48, 64}
+	Q32_32 = Format{32, 32, 64}
+	Q16_16 = Format{16, 16, 32}
+	Q8_24  = Format{8, 24, 32}
+	Q8_8   = Format{8, 8, 16}
+	Q4_12  = Format{4, 12, 16}
+)
+
+// the active format and the values derived from it; see SetFormat
+var (
+	format   = Q16_48
+	fracBits uint
+	fracMask fixed
+	MAX      fixed
+	MIN      fixed
+)
+
+// constants of exp and friends, in the active format
+var (
+	LN2     fixed
+	LN2_H   fixed
+	LN2_L   fixed
+	INV_LN2 fixed
+
+	ONE_HALF fixed
+	ONE      fixed
+	TWO      fixed
+
+	P1 fixed
+	P2 fixed
+	P3 fixed
+	P4 fixed
+	P5 fixed
+
+	LG1 fixed
+	LG2 fixed
+	LG3 fixed
+	LG4 fixed
+	LG5 fixed
+	LG6 fixed
+	LG7 fixed
+
+	SQRT2 fixed
+
+	PI      fixed
+	HALF_PI fixed
+
+	sigmoidCutoff fixed
+	// leakySlope is the slope of ActivationLeakyReLU below zero, 0.01
+	leakySlope fixed
+)
+
+// reference values of the constants above, in Q16.48
+const (
+	ln2Q48    = 0xB17217F7D1CF
+	ln2HQ48   = 0xB17217F7D1CE
+	invLn2Q48 = 0x171547652B82F
+
+	p1Q48 = 0x2AAAAAAAAAAA
+	p2Q48 = -0x00B60B60B60B
+	p3Q48 = 0x0004559AAF00
+	p4Q48 = -0x00001BBD0000
+	p5Q48 = 0x000000B20000
+
+	lg1Q48 = 0xAAAAAAAAAAAD
+	lg2Q48 = 0x666666665FE8
+	lg3Q48 = 0x492492508A4D
+	lg4Q48 = 0x38E38A3B1CF1
+	lg5Q48 = 0x2E8CC92D9608
+	lg6Q48 = 0x273413A0F18D
+	lg7Q48 = 0x25E225BE7CA5
+
+	sqrt2Q48 = 0x16A09E667F3BD
+
+	leakySlopeQ48 = 0x28F5C28F5C3
+)
+
+func init() {
+	if err := SetFormat(Q16_48); err != nil {
+		panic(err)
+	}
+}
+
+func (f Format) Bits() int {
+	return f.IntBits + f.FracBits
+}
+
+func (f Format) String() string {
+	return fmt.Sprintf("Q%d.%d", f.IntBits, f.FracBits)
+}
+
+func (f Format) valid() bool {
+	switch f.Width {
+	case 8, 16, 32, 64:
+	default:
+		return false
+	}
+	// exp and ln work with TWO, so at least [-4, 4) has to be representable,
+	// and the PLAN sigmoid needs its 1/32 steps
+	return f.IntBits >= 3 && f.FracBits >= 5 && f.Bits() <= f.Width
+}
+
+// ParseFormat reads a format written as "Q8.24"; the storage width is the
+// smallest machine word that holds it.
+func ParseFormat(s string) (Format, error) {
+	var f Format
+	if _, err := fmt.Sscanf(s, "Q%d.%d", &f.IntBits, &f.FracBits); err != nil {
+		return f, fmt.Errorf("%w: %q", ErrFormat, s)
+	}
+	for _, w := range []int{8, 16, 32, 64} {
+		if f.Bits() <= w {
+			f.Width = w
+			break
+		}
+	}
+	if !f.valid() {
+		return f, fmt.Errorf("%w: %q", ErrFormat, s)
+	}
+	return f, nil
+}
+
+// SetFormat switches every fixed point operation to f. Values created under
+// the previous format are not converted; see convertFormat.
+func SetFormat(f Format) error {
+	if !f.valid() {
+		return fmt.Errorf("%w: %v in %d bits", ErrFormat, f, f.Width)
+	}
+	format = f
+	fracBits = uint(f.FracBits)
+	fracMask = fixed(1)<<fracBits - 1
+	MAX = fixed(uint64(1)<<uint(f.Bits()-1) - 1)
+	MIN = -MAX - 1
+
+	ONE = fixed(1) << fracBits
+	TWO = ONE << 1
+	ONE_HALF = ONE >> 1
+
+	LN2 = fromQ48(ln2Q48)
+	LN2_H = fromQ48(ln2HQ48)
+	LN2_L = LN2 - LN2_H
+	INV_LN2 = fromQ48(invLn2Q48)
+
+	P1 = fromQ48(p1Q48)
+	P2 = fromQ48(p2Q48)
+	P3 = fromQ48(p3Q48)
+	P4 = fromQ48(p4Q48)
+	P5 = fromQ48(p5Q48)
+
+	LG1 = fromQ48(lg1Q48)
+	LG2 = fromQ48(lg2Q48)
+	LG3 = fromQ48(lg3Q48)
+	LG4 = fromQ48(lg4Q48)
+	LG5 = fromQ48(lg5Q48)
+	LG6 = fromQ48(lg6Q48)
+	LG7 = fromQ48(lg7Q48)
+
+	SQRT2 = fromQ48(sqrt2Q48)
+
+	PI = convertFormat(piQ60, cordicFormat, f)
+	HALF_PI = convertFormat(piQ60>>1, cordicFormat, f)
+
+	sigmoidCutoff = MAX
+	if(f.IntBits > 5){
+		sigmoidCutoff = fixed(10) << fracBits
+	}
+	leakySlope = fromQ48(leakySlopeQ48)
+	buildSigmoidTable()
+	return nil
+}
+
+// fromQ48 rounds a Q16.48 constant to the active format.
+func fromQ48(x int64) fixed {
+	return convertFormat(fixed(x), Q16_48, format)
+}
+
+// convertFormat rounds x from one format's scaling to another's and wraps it
+// to the destination width.
+func convertFormat(x fixed, from, to Format) fixed {
+	s := to.FracBits - from.FracBits
+	switch {
+	case s > 0:
+		x <<= uint(s)
+	case s < 0:
+		x = (x + fixed(1)<<uint(-s-1)) >> uint(-s)
+	}
+	return wrapTo(x, to)
+}
+
+// wrap reduces x to the active format's width the way a register of that
+// width would.
+func wrap(x fixed) fixed {
+	return wrapTo(x, format)
+}
+
+func wrapTo(x fixed, f Format) fixed {
+	s := uint(64 - f.Bits())
+	return (x << s) >> s
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// gemmTile is the edge of the square blocks Product works on; three blocks
+// of fixed values take 96KB, which stays in L2.
+const gemmTile = 64
+
+// gemmMinWork is the number of multiply-adds below which Product runs on the
+// calling goroutine.
+const gemmMinWork = 1 << 15
+
+var workers = runtime.GOMAXPROCS(0)
+
+// Accumulator selects how Product sums the products of a dot product.
+type Accumulator int
+
+const (
+	// Narrow rounds every product to the active format and adds them up in
+	// it, overflowing as soon as a partial sum does
+	Narrow Accumulator = iota
+	// Wide adds the exact 128-bit products, the way a MAC unit with a wide
+	// accumulator does, and rounds and saturates or wraps once at the end
+	Wide
+	numAccumulators
+)
+
+var accumulatorNames = [numAccumulators]string{"narrow", "wide"}
+
+func (a Accumulator) String() string {
+	return accumulatorNames[a]
+}
+
+// ParseAccumulator is the inverse of Accumulator.String.
+func ParseAccumulator(name string) (Accumulator, bool) {
+	for a, n := range accumulatorNames {
+		if n == name {
+			return Accumulator(a), true
+		}
+	}
+	return Narrow, false
+}
+
+// SetWorkers sets how many goroutines Product and its transposed variants
+// split the result rows between.
+func SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	workers = n
+}
+
+func (m *Matrix) ProductTransA(a, b Mat) {
+	if err := m.ProductTransAErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+// ProductTransAErr sets m to aᵀ·b without transposing a.
+func (m *Matrix) ProductTransAErr(a, b Mat) error {
+	return m.gemm("ProductTransA", Narrow, AsMatrix(a), true, AsMatrix(b), false)
+}
+
+func (m *Matrix) ProductTransB(a, b Mat) {
+	if err := m.ProductTransBErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+// ProductTransBErr sets m to a·bᵀ without transposing b.
+func (m *Matrix) ProductTransBErr(a, b Mat) error {
+	return m.gemm("ProductTransB", Narrow, AsMatrix(a), false, AsMatrix(b), true)
+}
+
+// ProductWith sets m to op(a)·op(b), op transposing its operand if ta or tb
+// is set, summing with acc.
+func (m *Matrix) ProductWith(acc Accumulator, a Mat, ta bool, b Mat, tb bool) error {
+	return m.gemm("ProductWith", acc, AsMatrix(a), ta, AsMatrix(b), tb)
+}
+
+func (a *Matrix) ProductInto(dst Mat, acc Accumulator, b Mat) error {
+	return dst.ProductWith(acc, a, false, b, false)
+}
+
+// gemm sets m to op(a)·op(b), op transposing its operand if ta or tb is set.
+// The result is worked out in gemmTile blocks; the blocks of a and b are
+// packed into row-major buffers first, so views and transposes cost nothing
+// extra. Every element still sums its products in order of k, so the result
+// and the overflow counts are the same as a plain triple loop, whatever the
+// number of workers. As with Product, m must not share storage with a or b.
+func (m *Matrix) gemm(op string, acc Accumulator, a *Matrix, ta bool, b *Matrix, tb bool) error {
+	ar, ac := a.Dims()
+	if ta {
+		ar, ac = ac, ar
+	}
+	br, bc := b.Dims()
+	if tb {
+		br, bc = bc, br
+	}
+	if ac != br {
+		return &ShapeError{Op: op, A: [2]int{ar, ac}, B: [2]int{br, bc}}
+	}
+	if err := m.holds(op, ar, bc); err != nil {
+		return err
+	}
+
+	tiles := (ar + gemmTile - 1) / gemmTile
+	n := workers
+	if n > tiles {
+		n = tiles
+	}
+	if ar*bc*ac < gemmMinWork || n <= 1 {
+		g := newGemmWorker(m, acc, a, ta, b, tb)
+		for t := 0; t < tiles; t++ {
+			g.rows(t * gemmTile)
+		}
+		return nil
+	}
+
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			g := newGemmWorker(m, acc, a, ta, b, tb)
+			for t := int(atomic.AddInt64(&next, 1)); t < tiles; t = int(atomic.AddInt64(&next, 1)) {
+				g.rows(t * gemmTile)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// gemmWorker holds one goroutine's packing buffers and accumulators.
+type gemmWorker struct {
+	m, a, b     *Matrix
+	ta, tb      bool
+	pa, pb, acc []fixed
+	wide        []wideSum
+}
+
+func newGemmWorker(m *Matrix, acc Accumulator, a *Matrix, ta bool, b *Matrix, tb bool) *gemmWorker {
+	g := &gemmWorker{
+		m: m, a: a, b: b, ta: ta, tb: tb,
+		pa: make([]fixed, gemmTile*gemmTile),
+		pb: make([]fixed, gemmTile*gemmTile),
+	}
+	if acc == Wide {
+		g.wide = make([]wideSum, gemmTile*gemmTile)
+	} else {
+		g.acc = make([]fixed, gemmTile*gemmTile)
+	}
+	return g
+}
+
+// rows works out the result rows i0 to i0+gemmTile.
+func (g *gemmWorker) rows(i0 int) {
+	rows, cols := g.m.Dims()
+	inner := g.a.col
+	if g.ta {
+		inner = g.a.row
+	}
+	ih := minInt(gemmTile, rows-i0)
+	for j0 := 0; j0 < cols; j0 += gemmTile {
+		jh := minInt(gemmTile, cols-j0)
+		if g.wide != nil {
+			g.blockWide(i0, j0, ih, jh, inner)
+		} else {
+			g.block(i0, j0, ih, jh, inner)
+		}
+	}
+}
+
+// block works out the ih x jh block of the result at (i0, j0), rounding
+// every product.
+func (g *gemmWorker) block(i0, j0, ih, jh, inner int) {
+	acc := g.acc[:ih*jh]
+	for i := range acc {
+		acc[i] = 0
+	}
+	for k0 := 0; k0 < inner; k0 += gemmTile {
+		kh := minInt(gemmTile, inner-k0)
+		pack(g.pa[:ih*kh], g.a, g.ta, i0, k0, ih, kh)
+		pack(g.pb[:kh*jh], g.b, g.tb, k0, j0, kh, jh)
+		for i := 0; i < ih; i++ {
+			row := acc[i*jh : (i+1)*jh]
+			for k, aik := range g.pa[i*kh : (i+1)*kh] {
+				if aik == 0 {
+					continue
+				}
+				for j, bkj := range g.pb[k*jh : (k+1)*jh] {
+					row[j] = addFixed(row[j], MultiplyFixed(aik, bkj), opProduct)
+				}
+			}
+		}
+	}
+	for i := 0; i < ih; i++ {
+		for j := 0; j < jh; j++ {
+			g.m.set(i0+i, j0+j, acc[i*jh+j])
+		}
+	}
+}
+
+// blockWide is block with exact sums, rounded once.
+func (g *gemmWorker) blockWide(i0, j0, ih, jh, inner int) {
+	acc := g.wide[:ih*jh]
+	for i := range acc {
+		acc[i] = wideSum{}
+	}
+	for k0 := 0; k0 < inner; k0 += gemmTile {
+		kh := minInt(gemmTile, inner-k0)
+		pack(g.pa[:ih*kh], g.a, g.ta, i0, k0, ih, kh)
+		pack(g.pb[:kh*jh], g.b, g.tb, k0, j0, kh, jh)
+		for i := 0; i < ih; i++ {
+			row := acc[i*jh : (i+1)*jh]
+			for k, aik := range g.pa[i*kh : (i+1)*kh] {
+				if aik == 0 {
+					continue
+				}
+				for j, bkj := range g.pb[k*jh : (k+1)*jh] {
+					row[j].add(mul128(aik, bkj))
+				}
+			}
+		}
+	}
+	for i := 0; i < ih; i++ {
+		for j := 0; j < jh; j++ {
+			g.m.set(i0+i, j0+j, acc[i*jh+j].fixed(opProduct, rounding))
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// pack copies the r x c block of op(m) at (i, j) into dst, row-major.
+func pack(dst []fixed, m *Matrix, t bool, i, j, r, c int) {
+	for x := 0; x < r; x++ {
+		row := dst[x*c : (x+1)*c]
+		for y := range row {
+			if t {
+				row[y] = m.at(j+y, i+x)
+			} else {
+				row[y] = m.at(i+x, j+y)
+			}
+		}
+	}
+}
+
+// productNaive is the triple loop Product used to be, kept to benchmark
+// against.
+func (m *Matrix) productNaive(a, b *Matrix) {
+	ar, ac := a.Dims()
+	_, bc := b.Dims()
+	for i := 0; i < ar; i++ {
+		for j := 0; j < bc; j++ {
+			var sum fixed = 0
+			for k := 0; k < ac; k++ {
+				sum = addFixed(sum, MultiplyFixed(a.at(i, k), b.at(k, j)), opProduct)
+			}
+			m.set(i, j, sum)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// productCases are the products of a 784x200 network, as in the -bench mode
+// of main: the hidden weights times one input, times a batch of 32, and
+// transposed times the hidden errors.
+func productCases() []struct {
+	name   string
+	a, b   *Matrix
+	ta, tb bool
+} {
+	const input, hidden = 784, 200
+	weights := NewMatrix(hidden, input, randomArray(input*hidden, input))
+	return []struct {
+		name   string
+		a, b   *Matrix
+		ta, tb bool
+	}{
+		{"W.x", weights, NewMatrix(input, 1, randomArray(input, 1)), false, false},
+		{"W.X32", weights, NewMatrix(input, 32, randomArray(input*32, 1)), false, false},
+		{"Wt.d", weights, NewMatrix(hidden, 1, randomArray(hidden, 1)), true, false},
+	}
+}
+
+func BenchmarkProductNaive(b *testing.B) {
+	for _, c := range productCases() {
+		x, y := c.a, c.b
+		if c.ta {
+			x = x.T()
+		}
+		if c.tb {
+			y = y.T()
+		}
+		r, _ := x.Dims()
+		_, col := y.Dims()
+		m := NewMatrix(r, col, nil)
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.productNaive(x, y)
+			}
+		})
+	}
+}
+
+func BenchmarkProductTiled(b *testing.B) {
+	benchmarkGemm(b, 1)
+}
+
+func BenchmarkProductTiledParallel(b *testing.B) {
+	benchmarkGemm(b, runtime.GOMAXPROCS(0))
+}
+
+func benchmarkGemm(b *testing.B, n int) {
+	defer SetWorkers(workers)
+	SetWorkers(n)
+	for _, c := range productCases() {
+		r, _ := c.a.Dims()
+		if c.ta {
+			_, r = c.a.Dims()
+		}
+		_, col := c.b.Dims()
+		m := NewMatrix(r, col, nil)
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := m.gemm(c.name, Narrow, c.a, c.ta, c.b, c.tb); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// gemmOperand returns a matrix m such that op(m), m transposed if t,
+// is r x c: a view inside a larger matrix if view is set, so that its stride is
+// not its width.
+func gemmOperand(rng *rand.Rand, r, c int, t, view bool) *Matrix {
+	if t {
+		r, c = c, r
+	}
+	pr, pc, i, j := r, c, 0, 0
+	if view {
+		pr, pc, i, j = r+3, c+5, 2, 3
+	}
+	m := NewMatrix(pr, pc, nil)
+	for x := 0; x < pr; x++ {
+		for y := 0; y < pc; y++ {
+			m.Set(x, y, randomFixed(rng))
+		}
+	}
+	return m.View(i, j, r, c)
+}
+
+// transposed is m, transposed if t.
+func transposed(m *Matrix, t bool) *Matrix {
+	if t {
+		return m.T()
+	}
+	return m
+}
+
+// equal reports whether a and b have the same shape and elements.
+func equal(a, b Mat) bool {
+	r, c := a.Dims()
+	if br, bc := b.Dims(); br != r || bc != c {
+		return false
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if a.At(i, j) != b.At(i, j) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestGemmMatchesNaive checks the tiled product, on one worker and on
+// several, against productNaive for Narrow and against an exact sum rounded
+// once for Wide, with transposed operands, views and shapes that leave
+// partial tiles, including the overflow counts.
+func TestGemmMatchesNaive(t *testing.T) {
+	defer SetWorkers(workers)
+	rng := rand.New(rand.NewSource(4))
+	shapes := []struct{ r, k, c int }{{1, 1, 1}, {3, 5, 2}, {70, 66, 65}, {129, 3, 1}}
+	for _, f := range []Format{Q16_48, Q8_8} {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			withFormat(t, f, a, RoundHalfEven, func() {
+				for _, s := range shapes {
+					for _, ta := range []bool{false, true} {
+						for _, tb := range []bool{false, true} {
+							view := rng.Intn(2) == 0
+							x := gemmOperand(rng, s.r, s.k, ta, view)
+							y := gemmOperand(rng, s.k, s.c, tb, !view)
+							name := func(acc Accumulator, n int) string {
+								return fmt.Sprintf("%v %v %dx%dx%d ta %v tb %v view %v %v x%d", f, a, s.r, s.k, s.c, ta, tb, view, acc, n)
+							}
+
+							ox, oy := transposed(x, ta), transposed(y, tb)
+							want := NewMatrix(s.r, s.c, nil)
+							ResetCounters()
+							want.productNaive(ox, oy)
+							wantCount := ReadCounters()[opProduct]
+
+							// Wide: the exact sum of the products, rounded once
+							wide := NewMatrix(s.r, s.c, nil)
+							var wideCount OpCount
+							sum, p := new(big.Int), new(big.Int)
+							for i := 0; i < s.r; i++ {
+								for j := 0; j < s.c; j++ {
+									sum.SetInt64(0)
+									for k := 0; k < s.k; k++ {
+										sum.Add(sum, p.Mul(big.NewInt(int64(ox.At(i, k))), big.NewInt(int64(oy.At(k, j)))))
+									}
+									q, _ := refRound(sum, rounding)
+									v, up, down := refFit(q)
+									wide.Set(i, j, v)
+									if up {
+										wideCount.Overflow++
+									}
+									if down {
+										wideCount.Underflow++
+									}
+								}
+							}
+
+							for _, n := range []int{1, 4} {
+								SetWorkers(n)
+								for acc, w := range map[Accumulator]*Matrix{Narrow: want, Wide: wide} {
+									wc := wantCount
+									if acc == Wide {
+										wc = wideCount
+									}
+									got := NewMatrix(s.r, s.c, nil)
+									ResetCounters()
+									if err := got.gemm("test", acc, x, ta, y, tb); err != nil {
+										t.Fatalf("%s: %v", name(acc, n), err)
+									}
+									if !equal(got, w) {
+										t.Fatalf("%s: differs from the reference", name(acc, n))
+									}
+									if c := ReadCounters()[opProduct]; c != wc {
+										t.Fatalf("%s: counted %+v, want %+v", name(acc, n), c, wc)
+									}
+								}
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestProductVariants checks the exported products against productNaive.
+func TestProductVariants(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	a := gemmOperand(rng, 67, 9, false, false)
+	b := gemmOperand(rng, 9, 66, false, true)
+	want := NewMatrix(67, 66, nil)
+	want.productNaive(a, b)
+
+	got := NewMatrix(67, 66, nil)
+	got.Product(a, b)
+	if !equal(got, want) {
+		t.Error("Product differs from productNaive")
+	}
+	got = NewMatrix(67, 66, nil)
+	got.ProductTransA(Copy(a.T()), b)
+	if !equal(got, want) {
+		t.Error("ProductTransA differs from productNaive")
+	}
+	got = NewMatrix(67, 66, nil)
+	got.ProductTransB(a, Copy(b.T()))
+	if !equal(got, want) {
+		t.Error("ProductTransB differs from productNaive")
+	}
+	got = NewMatrix(67, 66, nil)
+	if err := a.ProductInto(got, Narrow, b); err != nil || !equal(got, want) {
+		t.Errorf("ProductInto differs from productNaive: %v", err)
+	}
+	if err := got.ProductErr(a, a); !errors.Is(err, ErrShape) {
+		t.Errorf("67x9 times 67x9 gave %v, want a shape error", err)
+	}
+	if err := NewMatrix(2, 2, nil).ProductErr(a, b); !errors.Is(err, ErrShape) {
+		t.Errorf("a 67x66 product into 2x2 gave %v, want a shape error", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Quantization reports what a conversion between float64 and fixed point
+// lost. The errors are original minus converted, worked out exactly, over
+// the elements that were in range; NaNs and elements outside the format are
+// only counted.
+type Quantization struct {
+	// Elements is the number of elements converted
+	Elements int
+	// Inexact is how many of them were rounded
+	Inexact int
+	// MaxErr is the largest absolute error and RMSErr the root mean square
+	// error over all in-range elements
+	MaxErr, RMSErr float64
+	// Overflows is how many elements were outside the format, saturated or
+	// wrapped by the arithmetic mode; NaNs how many were NaN and became 0
+	Overflows, NaNs int
+}
+
+// Exact reports whether the conversion lost nothing.
+func (q Quantization) Exact() bool {
+	return q.Inexact == 0 && q.Overflows == 0 && q.NaNs == 0
+}
+
+func (q Quantization) String() string {
+	return fmt.Sprintf("%d of %d elements rounded, max error %.3g, rms error %.3g, %d out of range, %d NaN",
+		q.Inexact, q.Elements, q.MaxErr, q.RMSErr, q.Overflows, q.NaNs)
+}
+
+// quantizer accumulates a Quantization one element at a time.
+type quantizer struct {
+	q     Quantization
+	sumSq float64
+}
+
+func (z *quantizer) add(err float64) {
+	z.q.Elements++
+	if err == 0 {
+		return
+	}
+	z.q.Inexact++
+	z.q.MaxErr = math.Max(z.q.MaxErr, math.Abs(err))
+	z.sumSq += err * err
+}
+
+func (z *quantizer) result() Quantization {
+	if n := z.q.Elements - z.q.Overflows - z.q.NaNs; n > 0 {
+		z.q.RMSErr = math.Sqrt(z.sumSq / float64(n))
+	}
+	return z.q
+}
+
+// FromDense quantizes any gonum matrix into a new *Matrix in the active
+// format, rounding with the package rounding mode.
+func FromDense(a mat.Matrix) (*Matrix, Quantization) {
+	r, c := a.Dims()
+	m := NewMatrix(r, c, nil)
+	var z quantizer
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			x := a.At(i, j)
+			v, err := FloatToFixedErr(x)
+			m.set(i, j, v)
+			switch err {
+			case ErrNaN:
+				z.q.Elements++
+				z.q.NaNs++
+			case ErrOverflow:
+				z.q.Elements++
+				z.q.Overflows++
+			default:
+				// both lie on the coarser of the two grids, so the
+				// difference is exact
+				z.add(x - toFloat(v))
+			}
+		}
+	}
+	return m, z.result()
+}
+
+// FloatFromDense copies any gonum matrix exactly into a new *FloatMatrix,
+// to run a Network on it with the Float64 backend.
+func FloatFromDense(a mat.Matrix) *FloatMatrix {
+	r, c := a.Dims()
+	m := NewFloatMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.data[i*c+j] = a.At(i, j)
+		}
+	}
+	return m
+}
+
+// ToDense copies m into a new mat.Dense. A *Matrix in a format with more than
+// 53 significant bits can lose its low bits to float64 rounding, which the
+// Quantization reports; a *FloatMatrix copies exactly.
+func ToDense(m Mat) (*mat.Dense, Quantization) {
+	r, c := m.Dims()
+	d := mat.NewDense(r, c, nil)
+	var z quantizer
+	f, isFixed := m.(*Matrix)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if !isFixed {
+				d.Set(i, j, m.AtFloat(i, j))
+				z.add(0)
+				continue
+			}
+			x, err := toFloatErr(f.at(i, j))
+			d.Set(i, j, x)
+			z.add(err)
+		}
+	}
+	return d, z.result()
+}
+
+// toFloatErr is toFloat together with the exact error x - toFloat(x).
+func toFloatErr(x fixed) (float64, float64) {
+	g := float64(int64(x))
+	var d int64
+	if g >= 0x1p63 {
+		// MAX rounded up to 2^63, which int64 cannot hold
+		d = -int64(uint64(1)<<63 - uint64(x))
+	} else {
+		d = int64(x) - int64(g)
+	}
+	return math.Ldexp(g, -int(fracBits)), math.Ldexp(float64(d), -int(fracBits))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// refQuantization is the Quantization of converting each of want to got,
+// worked out exactly, besides overflows and nans that were only counted.
+func refQuantization(want []*big.Float, got []*big.Float, overflows, nans int) Quantization {
+	q := Quantization{Elements: len(want) + overflows + nans, Overflows: overflows, NaNs: nans}
+	var sumSq float64
+	for i := range want {
+		e, _ := new(big.Float).Sub(want[i], got[i]).Float64()
+		if e != 0 {
+			q.Inexact++
+		}
+		q.MaxErr = math.Max(q.MaxErr, math.Abs(e))
+		sumSq += e * e
+	}
+	if len(want) > 0 {
+		q.RMSErr = math.Sqrt(sumSq / float64(len(want)))
+	}
+	return q
+}
+
+// sameQuantization reports whether q is want, to the rounding of the sum
+// of squares.
+func sameQuantization(q, want Quantization) bool {
+	rms := q.RMSErr
+	q.RMSErr = want.RMSErr
+	return q == want && math.Abs(rms-want.RMSErr) <= 1e-12*want.RMSErr
+}
+
+// fixedFloat is x in ulps as an exact big.Float.
+func fixedFloat(x fixed) *big.Float {
+	return new(big.Float).SetMantExp(new(big.Float).SetInt64(int64(x)), -int(fracBits))
+}
+
+// TestDenseRoundTrip checks ToDense against the exact values, and that
+// FromDense gives the matrix back whenever ToDense was exact.
+func TestDenseRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(15))
+	for _, f := range testFormats {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			m := NewMatrix(7, 9, nil)
+			for i, v := range edges() {
+				m.Set(0, i, v)
+			}
+			for i := 1; i < 7; i++ {
+				for j := 0; j < 9; j++ {
+					m.Set(i, j, randomFixed(rng))
+				}
+			}
+			// and as a transposed view
+			v := NewMatrix(12, 10, nil).View(2, 1, 9, 7).T()
+			for i := 0; i < 7; i++ {
+				for j := 0; j < 9; j++ {
+					v.Set(i, j, m.At(i, j))
+				}
+			}
+			for _, src := range []*Matrix{m, v} {
+				d, q := ToDense(src)
+				var want, got []*big.Float
+				for i := 0; i < 7; i++ {
+					for j := 0; j < 9; j++ {
+						want = append(want, fixedFloat(m.At(i, j)))
+						got = append(got, new(big.Float).SetFloat64(d.At(i, j)))
+						if d.At(i, j) != toFloat(m.At(i, j)) {
+							t.Fatalf("%v: ToDense (%d, %d) = %v, want %v", f, i, j, d.At(i, j), toFloat(m.At(i, j)))
+						}
+					}
+				}
+				if wq := refQuantization(want, got, 0, 0); !sameQuantization(q, wq) {
+					t.Errorf("%v: ToDense reported %v, want %v", f, q, wq)
+				}
+				if f.Bits() <= 53 && !q.Exact() {
+					t.Errorf("%v: ToDense of %d bits was not exact: %v", f, f.Bits(), q)
+				}
+				back, bq := FromDense(d)
+				if q.Exact() && (!equal(back, m) || !bq.Exact()) {
+					t.Errorf("%v: FromDense(ToDense(m)) differs from m: %v", f, bq)
+				}
+			}
+		})
+	}
+}
+
+// TestFromDenseQuantization checks FromDense against FloatToFixedRound and
+// its report against the exact errors, with elements out of range and NaN.
+func TestFromDenseQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(16))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			for _, r := range testRoundings {
+				withFormat(t, f, a, r, func() {
+					d := mat.NewDense(8, 5, nil)
+					var want, got []*big.Float
+					var overflows, nans int
+					for i := 0; i < 8; i++ {
+						for j := 0; j < 5; j++ {
+							x := math.Ldexp(rng.NormFloat64(), rng.Intn(f.IntBits+8)-8)
+							switch rng.Intn(20) {
+							case 0:
+								x = math.NaN()
+							case 1:
+								x = math.Ldexp(math.Copysign(1.5, x), f.IntBits)
+							case 2:
+								x = float64(rng.Intn(9)-4) / 4
+							}
+							d.Set(i, j, x)
+						}
+					}
+					m, q := FromDense(d)
+					for i := 0; i < 8; i++ {
+						for j := 0; j < 5; j++ {
+							x := d.At(i, j)
+							v, err := FloatToFixedErr(x)
+							if m.At(i, j) != v {
+								t.Fatalf("%v %v %v: FromDense of %v = %d, want %d", f, a, r, x, m.At(i, j), v)
+							}
+							switch {
+							case math.IsNaN(x):
+								nans++
+							case err != nil:
+								overflows++
+							default:
+								want = append(want, new(big.Float).SetFloat64(x))
+								got = append(got, fixedFloat(v))
+							}
+						}
+					}
+					if wq := refQuantization(want, got, overflows, nans); !sameQuantization(q, wq) {
+						t.Errorf("%v %v %v: FromDense reported %v, want %v", f, a, r, q, wq)
+					}
+					if q.Exact() {
+						t.Errorf("%v %v %v: FromDense of out of range values reported exact", f, a, r)
+					}
+				})
+			}
+		}
+	}
+
+	exact := mat.NewDense(2, 2, []float64{0.5, -1.25, 3, 0})
+	m, q := FromDense(exact)
+	if !q.Exact() || q.Elements != 4 || q.MaxErr != 0 || q.RMSErr != 0 {
+		t.Errorf("FromDense of exact values reported %v", q)
+	}
+	if fm := FloatFromDense(exact); !mat.Equal(exact, mustDense(t, fm)) || !equal(AsMatrix(fm), m) {
+		t.Error("FloatFromDense differs from the matrix")
+	}
+	if s := q.String(); s != "0 of 4 elements rounded, max error 0, rms error 0, 0 out of range, 0 NaN" {
+		t.Errorf("String = %q", s)
+	}
+}
+
+// mustDense is ToDense of m, failing the test if it was not exact.
+func mustDense(t *testing.T, m Mat) *mat.Dense {
+	t.Helper()
+	d, q := ToDense(m)
+	if !q.Exact() {
+		t.Fatalf("ToDense was not exact: %v", q)
+	}
+	return d
+}
@@ -0,0 +1,153 @@
+package main
+
+// Options are the Network settings its layers compute with.
+type Options struct {
+	Squash      Squash
+	Accumulator Accumulator
+	// Loss is what the errors given to the output layer come from, so it
+	// can tell whether they are already the errors at the inputs of its
+	// activation; the other layers are given LossMSE
+	Loss Loss
+}
+
+// Layer is one step of a Network's feedforward. Layers keep no state
+// between calls: the Network hands Backward the inputs and outputs of the
+// Forward it is differentiating, so copies of a Network can share layers.
+type Layer interface {
+	// Forward returns the outputs of the layer for in, a column per sample
+	Forward(o Options, in Mat) (Mat, error)
+	// Backward takes the errors at out, target minus output, and returns
+	// the step of each of Params, in the same order, that moves out towards
+	// the target, unscaled by the learning rate. If propagate is set it also
+	// returns the errors at in, which the layer below trains on, and nil
+	// otherwise.
+	Backward(o Options, in, out, errs Mat, propagate bool) (Mat, []Mat, error)
+	// Params returns the trained matrices of the layer
+	Params() []Mat
+	// WithParams returns a copy of the layer using ps in place of Params
+	WithParams(ps []Mat) Layer
+}
+
+// activated is a Layer whose activation can be chosen.
+type activated interface {
+	Layer
+	Activation() Activation
+	WithActivation(f Activation) Layer
+}
+
+// Dense is a fully connected layer: its activation of the product of its
+// weights, an outputs x inputs matrix, with the inputs plus its bias, an
+// outputs x 1 column added to every sample.
+type Dense struct {
+	weights    Mat
+	bias       Mat
+	activation Activation
+	// pruned copy of weights for Forward, dropped once the weights change
+	sparse *Sparse
+}
+
+// NewDense returns a sigmoid Dense layer with weights uniform in
+// +-1/sqrt(inputs) and zero biases, the random weights being enough to tell
+// the neurons apart.
+func NewDense(inputs, outputs int) *Dense {
+	return &Dense{
+		weights: NewMatrix(outputs, inputs, randomArray(inputs*outputs, inputs)),
+		bias:    NewMatrix(outputs, 1, nil),
+	}
+}
+
+func (d *Dense) Dims() (inputs, outputs int) {
+	outputs, inputs = d.weights.Dims()
+	return
+}
+
+func (d *Dense) Forward(o Options, in Mat) (Mat, error) {
+	var w Multiplier = d.weights
+	if d.sparse != nil {
+		w = d.sparse
+	}
+	z, err := dotErr(o.Accumulator, w, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := z.AddBroadcastErr(z, d.bias); err != nil {
+		return nil, err
+	}
+	return activate(d.activation, o.Squash, z), nil
+}
+
+// Backward returns delta . in^T for the weights and delta summed over the
+// samples for the bias, delta being errs times the derivative of the
+// activation, and weights^T . errs as the errors at in. If o.Loss simplifies
+// the activation, errs are already the errors at its inputs and are delta.
+func (d *Dense) Backward(o Options, in, out, errs Mat, propagate bool) (Mat, []Mat, error) {
+	var inErrs Mat
+	if propagate {
+		var err error
+		inErrs, err = dotTransAErr(o.Accumulator, d.weights, errs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var delta Mat
+	var err error
+	switch {
+	case o.Loss.simplifies(d.activation):
+		delta = errs
+	case d.activation == ActivationSoftmax:
+		delta, err = softmaxPrime(out, errs)
+	default:
+		delta, err = multiplyErr(errs, activatePrime(d.activation, out))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	grad, err := dotTransBErr(o.Accumulator, delta, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the bias gradient is the sum over the samples
+	r, _ := delta.Dims()
+	biasGrad := delta.New(r, 1)
+	if err := biasGrad.SumAlongErr(delta, AlongRows); err != nil {
+		return nil, nil, err
+	}
+	return inErrs, []Mat{grad, biasGrad}, nil
+}
+
+// Params returns the weights and the bias.
+func (d *Dense) Params() []Mat {
+	return []Mat{d.weights, d.bias}
+}
+
+// WithParams keeps the pruned copy only if the weights are the same Mat.
+func (d *Dense) WithParams(ps []Mat) Layer {
+	o := &Dense{weights: ps[0], bias: ps[1], activation: d.activation}
+	if ps[0] == d.weights {
+		o.sparse = d.sparse
+	}
+	return o
+}
+
+func (d *Dense) Activation() Activation {
+	return d.activation
+}
+
+// WithActivation returns a copy of d using f.
+func (d *Dense) WithActivation(f Activation) Layer {
+	o := *d
+	o.activation = f
+	return &o
+}
+
+// prune returns a copy of d with every weight of magnitude at most
+// threshold zeroed, and the sparse copy kept if b is FixedPoint. The bias is
+// kept whole.
+func (d *Dense) prune(threshold fixed, b Backend) *Dense {
+	s := NewCSR(AsMatrix(d.weights), threshold)
+	o := &Dense{weights: b.convert(s.Dense()), bias: d.bias, activation: d.activation}
+	if b == FixedPoint {
+		o.sparse = s
+	}
+	return o
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+var (
+	ErrSingular            = errors.New("mat: matrix is singular")
+	ErrNotPositiveDefinite = errors.New("mat: matrix is not positive definite")
+)
+
+// The decompositions never divide by a zero pivot: a pivot that is zero, or
+// so small that dividing by it leaves the format, gives an error wrapping
+// ErrSingular or ErrNotPositiveDefinite instead.
+
+// Identity returns the n x n identity matrix.
+func Identity(n int) *Matrix {
+	m := NewMatrix(n, n, nil)
+	for i := 0; i < n; i++ {
+		m.set(i, i, ONE)
+	}
+	return m
+}
+
+// LU is the factorization P·A = L·U of a square matrix, with L unit lower
+// triangular and U upper triangular, both kept in lu.
+type LU struct {
+	lu    *Matrix
+	pivot []int
+	sign  int
+}
+
+// NewLU factorizes a by Gaussian elimination with partial pivoting, which
+// keeps every multiplier of L within [-1, 1] so they cannot overflow.
+func NewLU(a *Matrix) (*LU, error) {
+	n, c := a.Dims()
+	if n != c {
+		return nil, &ShapeError{Op: "NewLU", A: [2]int{n, c}, B: [2]int{c, n}}
+	}
+	lu := &LU{lu: Copy(a), pivot: make([]int, n), sign: 1}
+	m := lu.lu
+	for i := range lu.pivot {
+		lu.pivot[i] = i
+	}
+	for k := 0; k < n; k++ {
+		p := k
+		for i := k + 1; i < n; i++ {
+			if magnitude(m.at(i, k)) > magnitude(m.at(p, k)) {
+				p = i
+			}
+		}
+		if p != k {
+			for j := 0; j < n; j++ {
+				x := m.at(k, j)
+				m.set(k, j, m.at(p, j))
+				m.set(p, j, x)
+			}
+			lu.pivot[k], lu.pivot[p] = lu.pivot[p], lu.pivot[k]
+			lu.sign = -lu.sign
+		}
+		pivot := m.at(k, k)
+		if pivot == 0 {
+			return lu, fmt.Errorf("%w: no pivot in column %d", ErrSingular, k)
+		}
+		for i := k + 1; i < n; i++ {
+			l, err := DivideFixedErr(m.at(i, k), pivot)
+			if err != nil {
+				return lu, fmt.Errorf("%w: column %d: %v", ErrSingular, k, err)
+			}
+			m.set(i, k, l)
+			if l == 0 {
+				continue
+			}
+			for j := k + 1; j < n; j++ {
+				m.set(i, j, subFixed(m.at(i, j), MultiplyFixed(l, m.at(k, j)), opSub))
+			}
+		}
+	}
+	return lu, nil
+}
+
+// Det is the determinant of the factorized matrix, the signed product of
+// the diagonal of U.
+func (lu *LU) Det() fixed {
+	d := make([]fixed, len(lu.pivot))
+	for i := range d {
+		d[i] = lu.lu.at(i, i)
+	}
+	return diagProduct(d, lu.sign < 0)
+}
+
+// Solve returns x with A·x = b for every column of b.
+func (lu *LU) Solve(b *Matrix) (*Matrix, error) {
+	n := len(lu.pivot)
+	br, bc := b.Dims()
+	if br != n {
+		return nil, &ShapeError{Op: "Solve", A: [2]int{n, n}, B: [2]int{br, bc}}
+	}
+	m := lu.lu
+	x := NewMatrix(n, bc, nil)
+	for i, p := range lu.pivot {
+		b.copyRow(x.data[i*bc:(i+1)*bc], p)
+	}
+	// forward substitution with L, whose diagonal is one
+	for i := 0; i < n; i++ {
+		for k := 0; k < i; k++ {
+			l := m.at(i, k)
+			for j := 0; j < bc; j++ {
+				x.set(i, j, subFixed(x.at(i, j), MultiplyFixed(l, x.at(k, j)), opSub))
+			}
+		}
+	}
+	// back substitution with U
+	for i := n - 1; i >= 0; i-- {
+		for k := i + 1; k < n; k++ {
+			u := m.at(i, k)
+			for j := 0; j < bc; j++ {
+				x.set(i, j, subFixed(x.at(i, j), MultiplyFixed(u, x.at(k, j)), opSub))
+			}
+		}
+		for j := 0; j < bc; j++ {
+			v, err := DivideFixedErr(x.at(i, j), m.at(i, i))
+			if err != nil {
+				return nil, fmt.Errorf("%w: row %d: %v", ErrSingular, i, err)
+			}
+			x.set(i, j, v)
+		}
+	}
+	return x, nil
+}
+
+// Inverse returns A⁻¹, solving for the columns of the identity.
+func (lu *LU) Inverse() (*Matrix, error) {
+	return lu.Solve(Identity(len(lu.pivot)))
+}
+
+func Det(a *Matrix) fixed {
+	d, err := DetErr(a)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DetErr is the determinant of a square matrix a; a singular a has
+// determinant zero rather than an error.
+func DetErr(a *Matrix) (fixed, error) {
+	lu, err := NewLU(a)
+	if errors.Is(err, ErrSingular) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return lu.Det(), nil
+}
+
+func Solve(a, b *Matrix) *Matrix {
+	x, err := SolveErr(a, b)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// SolveErr returns x with a·x = b, a being square.
+func SolveErr(a, b *Matrix) (*Matrix, error) {
+	lu, err := NewLU(a)
+	if err != nil {
+		return nil, err
+	}
+	return lu.Solve(b)
+}
+
+func Inverse(a *Matrix) *Matrix {
+	x, err := InverseErr(a)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func InverseErr(a *Matrix) (*Matrix, error) {
+	lu, err := NewLU(a)
+	if err != nil {
+		return nil, err
+	}
+	return lu.Inverse()
+}
+
+// Cholesky is the factorization A = L·Lᵀ of a symmetric positive definite
+// matrix, L being lower triangular with a positive diagonal.
+type Cholesky struct {
+	l *Matrix
+}
+
+// NewCholesky factorizes a, reading only its lower triangle. The sums of
+// products are exact and rounded once, like the Wide accumulator.
+func NewCholesky(a *Matrix) (*Cholesky, error) {
+	n, c := a.Dims()
+	if n != c {
+		return nil, &ShapeError{Op: "NewCholesky", A: [2]int{n, c}, B: [2]int{c, n}}
+	}
+	l := NewMatrix(n, n, nil)
+	for j := 0; j < n; j++ {
+		var s wideSum
+		for k := 0; k < j; k++ {
+			s.add(mul128(l.at(j, k), l.at(j, k)))
+		}
+		d := subFixed(a.at(j, j), s.fixed(opProduct, rounding), opSub)
+		if d <= 0 {
+			return nil, fmt.Errorf("%w: pivot %d is %v", ErrNotPositiveDefinite, j, d)
+		}
+		ljj := sqrt(d)
+		if ljj == 0 {
+			return nil, fmt.Errorf("%w: pivot %d is too small for the format", ErrNotPositiveDefinite, j)
+		}
+		l.set(j, j, ljj)
+		for i := j + 1; i < n; i++ {
+			var s wideSum
+			for k := 0; k < j; k++ {
+				s.add(mul128(l.at(i, k), l.at(j, k)))
+			}
+			v, err := DivideFixedErr(subFixed(a.at(i, j), s.fixed(opProduct, rounding), opSub), ljj)
+			if err != nil {
+				return nil, fmt.Errorf("%w: column %d: %v", ErrNotPositiveDefinite, j, err)
+			}
+			l.set(i, j, v)
+		}
+	}
+	return &Cholesky{l}, nil
+}
+
+// L returns the lower triangular factor.
+func (ch *Cholesky) L() *Matrix {
+	return Copy(ch.l)
+}
+
+// Det is the determinant of the factorized matrix, the square of the
+// product of the diagonal of L.
+func (ch *Cholesky) Det() fixed {
+	n, _ := ch.l.Dims()
+	d := make([]fixed, 0, 2*n)
+	for i := 0; i < n; i++ {
+		d = append(d, ch.l.at(i, i), ch.l.at(i, i))
+	}
+	return diagProduct(d, false)
+}
+
+// Solve returns x with A·x = b for every column of b, substituting forward
+// with L and back with Lᵀ.
+func (ch *Cholesky) Solve(b *Matrix) (*Matrix, error) {
+	n, _ := ch.l.Dims()
+	br, bc := b.Dims()
+	if br != n {
+		return nil, &ShapeError{Op: "Solve", A: [2]int{n, n}, B: [2]int{br, bc}}
+	}
+	l := ch.l
+	x := Copy(b)
+	for i := 0; i < n; i++ {
+		for k := 0; k < i; k++ {
+			for j := 0; j < bc; j++ {
+				x.set(i, j, subFixed(x.at(i, j), MultiplyFixed(l.at(i, k), x.at(k, j)), opSub))
+			}
+		}
+		for j := 0; j < bc; j++ {
+			x.set(i, j, DivideFixed(x.at(i, j), l.at(i, i)))
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		for k := i + 1; k < n; k++ {
+			for j := 0; j < bc; j++ {
+				x.set(i, j, subFixed(x.at(i, j), MultiplyFixed(l.at(k, i), x.at(k, j)), opSub))
+			}
+		}
+		for j := 0; j < bc; j++ {
+			x.set(i, j, DivideFixed(x.at(i, j), l.at(i, i)))
+		}
+	}
+	return x, nil
+}
+
+// diagProduct multiplies xs, negated if neg, keeping the running product as a
+// 64-bit mantissa and a binary exponent so that a partial product outside the
+// format does not spoil one that fits; only the result is rounded to the
+// format, counting an overflow against product.
+func diagProduct(xs []fixed, neg bool) fixed {
+	// the product is mant * 2^exp, with the top bit of mant set
+	mant, exp := uint64(1)<<63, -63
+	for _, x := range xs {
+		if x == 0 {
+			return 0
+		}
+		if x < 0 {
+			neg = !neg
+		}
+		hi, lo := bits.Mul64(mant, magnitude(x))
+		exp -= int(fracBits)
+		if hi == 0 {
+			hi, lo = lo, 0
+			exp -= 64
+		}
+		z := bits.LeadingZeros64(hi)
+		mant = hi<<uint(z) | lo>>uint(64-z)
+		if lo<<uint(z) != 0 {
+			// sticky bit for the final rounding
+			mant |= 1
+		}
+		exp += 64 - z
+	}
+
+	// in units of an ulp the product is mant * 2^s
+	s := exp + int(fracBits)
+	switch {
+	case s >= 64:
+		return overflowed(opProduct, !neg, 0)
+	case s < -63:
+		sticky := mant<<uint(127+s) != 0 || s < -127
+		mant >>= uint(-63 - s)
+		if sticky {
+			mant |= 1
+		}
+		s = -63
+	}
+	x := int128{0, mant}
+	if s > 0 {
+		x = int128{mant >> uint(64-s), mant << uint(s)}
+	}
+	if neg {
+		x = x.neg()
+	}
+	if s < 0 {
+		x = x.shr(uint(-s), rounding)
+	}
+	return x.fixed(opProduct)
+}
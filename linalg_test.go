@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// TestDetTinyTie checks determinants of diagonal matrices whose exact
+// value is an odd number of half ulps, below 2^-63 ulp of mantissa in
+// diagProduct, under each rounding mode.
+func TestDetTinyTie(t *testing.T) {
+	// 2^-24 * 2^-25 is half an ulp of Q16.48 and 3 * 2^-25 * 2^-24 is one
+	// and a half
+	half := []fixed{1 << 24, 1 << 23}
+	threeHalves := []fixed{3 << 23, 1 << 24}
+	cases := []struct {
+		name  string
+		diag  []fixed
+		neg   bool
+		round [3]fixed // Truncate, RoundHalfUp, RoundHalfEven
+	}{
+		{"1/2", half, false, [3]fixed{0, 1, 0}},
+		{"-1/2", half, true, [3]fixed{-1, 0, 0}},
+		{"3/2", threeHalves, false, [3]fixed{1, 2, 2}},
+		{"-3/2", threeHalves, true, [3]fixed{-2, -1, -2}},
+	}
+	for _, c := range cases {
+		for i, r := range testRoundings {
+			withFormat(t, Q16_48, Wrap, r, func() {
+				if got := diagProduct(c.diag, c.neg); got != c.round[i] {
+					t.Errorf("%s %v: diagProduct = %d ulp, want %d", c.name, r, got, c.round[i])
+				}
+				a := NewMatrix(2, 2, []fixed{c.diag[0], 0, 0, c.diag[1]})
+				if c.neg {
+					a.Set(0, 0, -c.diag[0])
+				}
+				if got := Det(a); got != c.round[i] {
+					t.Errorf("%s %v: Det = %d ulp, want %d", c.name, r, got, c.round[i])
+				}
+			})
+		}
+	}
+}
+
+// testSystem returns a random n x n matrix of the active format that is
+// diagonally dominant, so well conditioned, with a determinant in range, and
+// its float64 copy. If spd is set the matrix is also symmetric, so positive
+// definite.
+func testSystem(rng *rand.Rand, n int, spd bool) (*Matrix, *mat.Dense) {
+	a := NewMatrix(n, n, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			v := (rng.Float64()*2 - 1) / float64(n+1)
+			if spd && j < i {
+				v = toFloat(a.At(j, i))
+			}
+			if i == j {
+				v += 1
+			}
+			a.Set(i, j, floatToFixed(v))
+		}
+	}
+	d, _ := ToDense(a)
+	return a, d
+}
+
+// closeTo reports the first element of m further than tol from want.
+func closeTo(m Mat, want mat.Matrix, tol float64) (i, j int, ok bool) {
+	r, c := want.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if math.Abs(m.AtFloat(i, j)-want.At(i, j)) > tol {
+				return i, j, false
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+func TestLU(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	for _, f := range []Format{Q16_48, Q8_24} {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			tol := math.Ldexp(1, 8-f.FracBits)
+			for _, n := range []int{1, 2, 3, 5, 8} {
+				a, d := testSystem(rng, n, false)
+				lu, err := NewLU(a)
+				if err != nil {
+					t.Fatalf("%v %d: %v", f, n, err)
+				}
+				if got, want := toFloat(lu.Det()), mat.Det(d); math.Abs(got-want) > tol*math.Abs(want) {
+					t.Errorf("%v %d: Det = %v, want %v", f, n, got, want)
+				}
+				if got := Det(a); got != lu.Det() {
+					t.Errorf("%v %d: Det(a) = %v, LU Det %v", f, n, got, lu.Det())
+				}
+
+				// a right-hand side of one or two columns, as a view
+				c := min(n, 2)
+				b, bd := testSystem(rng, n, false)
+				x, err := lu.Solve(b.View(0, 0, n, c))
+				if err != nil {
+					t.Fatalf("%v %d: Solve: %v", f, n, err)
+				}
+				var want mat.Dense
+				if err := want.Solve(d, bd.Slice(0, n, 0, c)); err != nil {
+					t.Fatal(err)
+				}
+				if i, j, ok := closeTo(x, &want, tol); !ok {
+					t.Errorf("%v %d: Solve (%d, %d) = %v, want %v", f, n, i, j, x.AtFloat(i, j), want.At(i, j))
+				}
+				if x2 := Solve(a, b.View(0, 0, n, c)); !equal(x, x2) {
+					t.Errorf("%v %d: Solve(a, b) differs from LU Solve", f, n)
+				}
+
+				inv, err := InverseErr(a)
+				if err != nil {
+					t.Fatalf("%v %d: Inverse: %v", f, n, err)
+				}
+				var winv mat.Dense
+				if err := winv.Inverse(d); err != nil {
+					t.Fatal(err)
+				}
+				if i, j, ok := closeTo(inv, &winv, tol); !ok {
+					t.Errorf("%v %d: Inverse (%d, %d) = %v, want %v", f, n, i, j, inv.AtFloat(i, j), winv.At(i, j))
+				}
+			}
+		})
+	}
+
+	// a row swap flips the sign of the determinant
+	swap := NewMatrix(2, 2, []fixed{0, ONE, ONE, 0})
+	if got := Det(swap); got != -ONE {
+		t.Errorf("Det of a swap = %v, want -1", toFloat(got))
+	}
+	if got := Inverse(swap); !equal(got, swap) {
+		t.Error("a swap is not its own inverse")
+	}
+}
+
+func TestCholesky(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, f := range []Format{Q16_48, Q8_24} {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			tol := math.Ldexp(1, 8-f.FracBits)
+			for _, n := range []int{1, 2, 3, 5, 8} {
+				a, d := testSystem(rng, n, true)
+				ch, err := NewCholesky(a)
+				if err != nil {
+					t.Fatalf("%v %d: %v", f, n, err)
+				}
+				l := ch.L()
+				for i := 0; i < n; i++ {
+					if l.At(i, i) <= 0 {
+						t.Errorf("%v %d: L(%d, %d) = %v is not positive", f, n, i, i, toFloat(l.At(i, i)))
+					}
+					for j := i + 1; j < n; j++ {
+						if l.At(i, j) != 0 {
+							t.Errorf("%v %d: L(%d, %d) = %v above the diagonal", f, n, i, j, toFloat(l.At(i, j)))
+						}
+					}
+				}
+				llt := NewMatrix(n, n, nil)
+				llt.ProductTransB(l, l)
+				if i, j, ok := closeTo(llt, d, tol); !ok {
+					t.Errorf("%v %d: L·Lᵀ (%d, %d) = %v, want %v", f, n, i, j, llt.AtFloat(i, j), d.At(i, j))
+				}
+				if got, want := toFloat(ch.Det()), mat.Det(d); math.Abs(got-want) > tol*math.Abs(want) {
+					t.Errorf("%v %d: Det = %v, want %v", f, n, got, want)
+				}
+
+				b, bd := testSystem(rng, n, false)
+				x, err := ch.Solve(b)
+				if err != nil {
+					t.Fatalf("%v %d: Solve: %v", f, n, err)
+				}
+				var want mat.Dense
+				if err := want.Solve(d, bd); err != nil {
+					t.Fatal(err)
+				}
+				if i, j, ok := closeTo(x, &want, tol); !ok {
+					t.Errorf("%v %d: Solve (%d, %d) = %v, want %v", f, n, i, j, x.AtFloat(i, j), want.At(i, j))
+				}
+			}
+		})
+	}
+}
+
+func TestLinalgErrors(t *testing.T) {
+	singular := NewMatrix(2, 2, []fixed{ONE, TWO, TWO, 2 * TWO})
+	if _, err := NewLU(singular); !errors.Is(err, ErrSingular) {
+		t.Errorf("NewLU of a singular matrix gave %v, want ErrSingular", err)
+	}
+	if d, err := DetErr(singular); d != 0 || err != nil {
+		t.Errorf("DetErr of a singular matrix = %v, %v, want 0, nil", d, err)
+	}
+	if _, err := SolveErr(singular, NewMatrix(2, 1, nil)); !errors.Is(err, ErrSingular) {
+		t.Errorf("SolveErr with a singular matrix gave %v, want ErrSingular", err)
+	}
+	if _, err := InverseErr(singular); !errors.Is(err, ErrSingular) {
+		t.Errorf("InverseErr of a singular matrix gave %v, want ErrSingular", err)
+	}
+
+	indefinite := NewMatrix(2, 2, []fixed{ONE, TWO, TWO, ONE})
+	if _, err := NewCholesky(indefinite); !errors.Is(err, ErrNotPositiveDefinite) {
+		t.Errorf("NewCholesky of an indefinite matrix gave %v, want ErrNotPositiveDefinite", err)
+	}
+	if _, err := NewCholesky(NewMatrix(2, 2, []fixed{-ONE, 0, 0, ONE})); !errors.Is(err, ErrNotPositiveDefinite) {
+		t.Errorf("NewCholesky with a negative pivot gave %v, want ErrNotPositiveDefinite", err)
+	}
+
+	wide := NewMatrix(2, 3, nil)
+	var se *ShapeError
+	if _, err := NewLU(wide); !errors.As(err, &se) {
+		t.Errorf("NewLU of 2x3 gave %v, want a *ShapeError", err)
+	}
+	if _, err := NewCholesky(wide); !errors.As(err, &se) {
+		t.Errorf("NewCholesky of 2x3 gave %v, want a *ShapeError", err)
+	}
+	if _, err := DetErr(wide); !errors.As(err, &se) {
+		t.Errorf("DetErr of 2x3 gave %v, want a *ShapeError", err)
+	}
+	lu, _ := NewLU(Identity(2))
+	if _, err := lu.Solve(NewMatrix(3, 1, nil)); !errors.As(err, &se) {
+		t.Errorf("Solve of 2x2 with a 3x1 b gave %v, want a *ShapeError", err)
+	}
+	ch, _ := NewCholesky(Identity(2))
+	if _, err := ch.Solve(NewMatrix(3, 1, nil)); !errors.As(err, &se) {
+		t.Errorf("Cholesky Solve of 2x2 with a 3x1 b gave %v, want a *ShapeError", err)
+	}
+}
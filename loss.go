@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math"
+)
+
+// Loss selects what Train minimises. Each is registered in losses with its
+// value and its gradient for one output y against its target t, in fixed
+// point and in float64 for the Float64 backend.
+//
+// The errors Train backpropagates are minus the gradient with respect to
+// the outputs up to a constant factor per loss, which the learning rate
+// absorbs: MSE trains on target - output rather than twice that over the
+// number of outputs.
+type Loss int
+
+const (
+	// LossMSE is the mean over the outputs of (target - output)^2
+	LossMSE Loss = iota
+	// LossMAE is the mean of |target - output|, trained on its sign
+	LossMAE
+	// LossHuber is the mean of (target - output)^2 / 2 for differences up
+	// to 1 and |target - output| - 1/2 beyond, so outliers are trained on
+	// as with MAE and the rest as with MSE
+	LossHuber
+	// LossBCE is the binary cross-entropy, the mean of -t*ln(y) -
+	// (1-t)*ln(1-y), for outputs in (0, 1) such as a sigmoid's
+	LossBCE
+	// LossCCE is the categorical cross-entropy -sum target*ln(output), for
+	// a softmax output layer and targets that sum to one
+	LossCCE
+	numLosses
+)
+
+var lossNames = [numLosses]string{"mse", "mae", "huber", "bce", "cce"}
+
+func (l Loss) String() string {
+	return lossNames[l]
+}
+
+// ParseLoss is the inverse of Loss.String.
+func ParseLoss(name string) (Loss, bool) {
+	for l, n := range lossNames {
+		if n == name {
+			return Loss(l), true
+		}
+	}
+	return LossMSE, false
+}
+
+// loss is an entry of the registry. grad is minus the gradient, scaled as
+// Loss describes.
+type loss struct {
+	value, grad               func(y, t fixed) fixed
+	float64Value, float64Grad func(y, t float64) float64
+}
+
+var losses = [numLosses]loss{
+	LossMSE: {
+		func(y, t fixed) fixed {
+			d := subFixed(t, y, opSub)
+			return MultiplyFixed(d, d)
+		},
+		func(y, t fixed) fixed { return subFixed(t, y, opSub) },
+		func(y, t float64) float64 { return (t - y) * (t - y) },
+		func(y, t float64) float64 { return t - y },
+	},
+	LossMAE: {
+		func(y, t fixed) fixed { return fixed(magnitude(subFixed(t, y, opSub))) },
+		func(y, t fixed) fixed { return sign(subFixed(t, y, opSub)) },
+		func(y, t float64) float64 { return math.Abs(t - y) },
+		func(y, t float64) float64 { return step(t > y, 1, step(t < y, -1, 0)) },
+	},
+	LossHuber: {
+		huber,
+		func(y, t fixed) fixed { return fixedMax(-ONE, fixedMin(subFixed(t, y, opSub), ONE)) },
+		func(y, t float64) float64 {
+			d := math.Abs(t - y)
+			return step(d <= 1, d*d/2, d-0.5)
+		},
+		func(y, t float64) float64 { return math.Max(-1, math.Min(t-y, 1)) },
+	},
+	LossBCE: {
+		bce, bceGrad,
+		func(y, t float64) float64 {
+			y = math.Max(math.SmallestNonzeroFloat64, math.Min(y, 1-0x1p-53))
+			return -t*math.Log(y) - (1-t)*math.Log(1-y)
+		},
+		func(y, t float64) float64 {
+			y = math.Max(math.SmallestNonzeroFloat64, math.Min(y, 1-0x1p-53))
+			return (t - y) / y / (1 - y)
+		},
+	},
+	LossCCE: {
+		func(y, t fixed) fixed {
+			// an output of zero is taken as 1 ulp, so ln stays finite
+			if t == 0 {
+				return 0
+			}
+			return -MultiplyFixed(t, logProb(fixedMax(y, 1)))
+		},
+		func(y, t fixed) fixed { return DivideFixed(t, fixedMax(y, 1)) },
+		func(y, t float64) float64 {
+			if t == 0 {
+				return 0
+			}
+			return -t * math.Log(math.Max(y, math.SmallestNonzeroFloat64))
+		},
+		func(y, t float64) float64 { return t / math.Max(y, math.SmallestNonzeroFloat64) },
+	},
+}
+
+func sign(x fixed) fixed {
+	switch {
+	case x > 0:
+		return ONE
+	case x < 0:
+		return -ONE
+	}
+	return 0
+}
+
+func huber(y, t fixed) fixed {
+	d := fixed(magnitude(subFixed(t, y, opSub)))
+	if d <= ONE {
+		return MultiplyFixed(ONE_HALF, MultiplyFixed(d, d))
+	}
+	return d - ONE_HALF
+}
+
+// probability clamps y to [1 ulp, ONE - 1 ulp], so the logarithms and
+// divisions of BCE stay finite.
+func probability(y fixed) fixed {
+	return fixedMax(1, fixedMin(y, ONE-1))
+}
+
+// logProb is ln(y) for y > 0, kept at or above -MAX in every arithmetic
+// mode so that the cross-entropies can negate it. In formats too narrow to
+// hold ln of 1 ulp the loss of a confident wrong output is then MAX.
+func logProb(y fixed) fixed {
+	l, err := lnErr(y)
+	if err != nil {
+		return -MAX
+	}
+	return fixedMax(l, -MAX)
+}
+
+// bce weighs the two logarithms by t and 1 - t, so for a target in [0, 1]
+// their sum is at least -MAX and its negation fits.
+func bce(y, t fixed) fixed {
+	y = probability(y)
+	a := MultiplyFixed(t, logProb(y))
+	b := MultiplyFixed(ONE-t, logProb(ONE-y))
+	return -fixedMax(a+b, -MAX)
+}
+
+// bceGrad is (t - y)/(y(1 - y)), dividing twice so the product cannot round
+// to zero.
+func bceGrad(y, t fixed) fixed {
+	y = probability(y)
+	return DivideFixed(DivideFixed(subFixed(t, y, opSub), y), ONE-y)
+}
+
+// simplifies reports whether l of the outputs of f has target - output as
+// its gradient at the inputs of f: cross-entropy of a softmax or of a
+// sigmoid. The output layer then trains on those errors as they are,
+// without differentiating f.
+func (l Loss) simplifies(f Activation) bool {
+	return l == LossCCE && f == ActivationSoftmax || l == LossBCE && f == ActivationSigmoid
+}
+
+// mean reports whether l is averaged over the outputs rather than summed.
+func (l Loss) mean() bool {
+	return l != LossCCE
+}
+
+// value returns the loss of the outputs y against the targets t, averaged
+// over the columns, in fixed point whatever Mat they are; a *FloatMatrix is
+// worked out in float64 and rounded once.
+func (l Loss) value(y, t Mat) fixed {
+	r, c := y.Dims()
+	if _, ok := y.(*FloatMatrix); ok {
+		var sum float64
+		for i := 0; i < r; i++ {
+			for j := 0; j < c; j++ {
+				sum += losses[l].float64Value(y.AtFloat(i, j), t.AtFloat(i, j))
+			}
+		}
+		if l.mean() {
+			sum /= float64(r)
+		}
+		return floatToFixed(sum / float64(c))
+	}
+	var sum fixed
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			sum = addFixed(sum, losses[l].value(y.At(i, j), t.At(i, j)), opAdd)
+		}
+	}
+	if l.mean() {
+		sum = DivideFixed(sum, fixed(r)<<fracBits)
+	}
+	return DivideFixed(sum, fixed(c)<<fracBits)
+}
+
+// errors returns the errors Train backpropagates from the outputs y of an
+// output layer with activation f, for the targets t: target - output if l
+// simplifies f, and otherwise minus the gradient of l.
+func (l Loss) errors(f Activation, y, t Mat) (Mat, error) {
+	errs, err := subtractErr(t, y)
+	if err != nil || l == LossMSE || l.simplifies(f) {
+		return errs, err
+	}
+	r, c := y.Dims()
+	fe, isFloat := errs.(*FloatMatrix)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if isFloat {
+				fe.SetFloat(i, j, losses[l].float64Grad(y.AtFloat(i, j), t.AtFloat(i, j)))
+			} else {
+				errs.Set(i, j, losses[l].grad(y.At(i, j), t.At(i, j)))
+			}
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// lossPoints are outputs and targets the losses are checked at; the Huber
+// and MAE ones include differences beyond 1 and of either sign.
+var lossPoints = []struct{ y, t float64 }{
+	{0.05, 0}, {0.05, 1}, {0.3, 0.25}, {0.5, 0.5}, {0.9, 1}, {0.99, 0},
+	{-1.5, 1}, {2.75, 0.5}, {0.125, 1},
+}
+
+func TestLossValues(t *testing.T) {
+	for l := Loss(0); l < numLosses; l++ {
+		for _, p := range lossPoints {
+			if (l == LossBCE || l == LossCCE) && (p.y <= 0 || p.y >= 1) {
+				continue
+			}
+			y, tg := floatToFixed(p.y), floatToFixed(p.t)
+			if got, want := toFloat(losses[l].value(y, tg)), losses[l].float64Value(p.y, p.t); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%v(%v, %v) = %v, want %v", l, p.y, p.t, got, want)
+			}
+			if got, want := toFloat(losses[l].grad(y, tg)), losses[l].float64Grad(p.y, p.t); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%v gradient at (%v, %v) = %v, want %v", l, p.y, p.t, got, want)
+			}
+		}
+	}
+}
+
+// TestLossGradients checks the float64 gradients, which the fixed ones are
+// checked against above, by central differences of the values: grad is
+// minus the derivative, halved for MSE as Loss describes.
+func TestLossGradients(t *testing.T) {
+	const h = 1e-6
+	for l := Loss(0); l < numLosses; l++ {
+		for _, p := range lossPoints {
+			if (l == LossBCE || l == LossCCE) && (p.y <= 0 || p.y >= 1) {
+				continue
+			}
+			d := (losses[l].float64Value(p.y+h, p.t) - losses[l].float64Value(p.y-h, p.t)) / (2 * h)
+			want := -d
+			if l == LossMSE {
+				want /= 2
+			}
+			if got := losses[l].float64Grad(p.y, p.t); math.Abs(got-want) > 1e-6*math.Max(1, math.Abs(want)) {
+				t.Errorf("%v gradient at (%v, %v) = %v, want %v", l, p.y, p.t, got, want)
+			}
+		}
+	}
+}
+
+// TestLossValueMean checks Loss.value on a batch, averaged over the columns
+// and, except for CCE, over the outputs, on both backends.
+func TestLossValueMean(t *testing.T) {
+	ys := []float64{0.2, 0.7, 0.1, 0.6, 0.3, 0.3}
+	ts := []float64{0, 1, 0, 1, 0, 0}
+	y, tg := NewMatrix(3, 2, nil), NewMatrix(3, 2, nil)
+	for i := range ys {
+		y.Set(i/2, i%2, floatToFixed(ys[i]))
+		tg.Set(i/2, i%2, floatToFixed(ts[i]))
+	}
+	for l := Loss(0); l < numLosses; l++ {
+		var want float64
+		for i := range ys {
+			want += losses[l].float64Value(ys[i], ts[i])
+		}
+		want /= 2
+		if l.mean() {
+			want /= 3
+		}
+		for _, b := range []Backend{FixedPoint, Float64} {
+			if got := toFloat(l.value(b.convert(y), b.convert(tg))); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%v %v: value = %v, want %v", b, l, got, want)
+			}
+		}
+	}
+}
+
+// TestCrossEntropyFits checks that a confident wrong output gives a loss in
+// the format, even where ln of 1 ulp is below MIN.
+func TestCrossEntropyFits(t *testing.T) {
+	for _, f := range []Format{Q16_48, Q4_12, {3, 29, 32}, {3, 13, 16}} {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			withFormat(t, f, a, RoundHalfEven, func() {
+				want := math.Min(float64(fracBits)*math.Ln2, toFloat(MAX))
+				for _, c := range []struct {
+					l    Loss
+					y, t fixed
+				}{
+					{LossCCE, 1, ONE},
+					{LossCCE, 0, ONE},
+					{LossBCE, 0, ONE},
+					{LossBCE, ONE, 0},
+				} {
+					got := losses[c.l].value(c.y, c.t)
+					if got < 0 || got > MAX || math.Abs(toFloat(got)-want) > 0.01 {
+						t.Errorf("%v %v: %v(%d, %d) = %d, want about %v", f, a, c.l, c.y, c.t, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestSimplifiedGradients checks that target - output, which Train hands a
+// softmax output layer under CCE and a sigmoid one under BCE, is minus the
+// gradient of the loss at the inputs of the activation: against central
+// differences in float64, and against the long way round, the loss gradient
+// through the activation's derivative, on both backends.
+func TestSimplifiedGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(21))
+	const h = 1e-6
+	z := NewFloatMatrix(4, 3, nil)
+	for i := range z.data {
+		z.data[i] = rng.Float64()*4 - 2
+	}
+	// a one-hot column, a mixed one and a soft one, each summing to one
+	tg := NewFloatMatrix(4, 3, []float64{
+		0, 0.25, 0.1,
+		1, 0, 0.2,
+		0, 0.75, 0.3,
+		0, 0, 0.4,
+	})
+	for _, c := range []struct {
+		l Loss
+		f Activation
+	}{
+		{LossCCE, ActivationSoftmax},
+		{LossBCE, ActivationSigmoid},
+	} {
+		if !c.l.simplifies(c.f) {
+			t.Fatalf("%v does not simplify %v", c.l, c.f)
+		}
+		activate := func(z *FloatMatrix) *FloatMatrix {
+			y := NewFloatMatrix(4, 3, nil)
+			y.Activate(c.f, SquashExact, z)
+			return y
+		}
+		// the loss of one column, summed over its outputs
+		loss := func(z *FloatMatrix, j int) float64 {
+			y := activate(z)
+			var sum float64
+			for i := 0; i < 4; i++ {
+				sum += losses[c.l].float64Value(y.data[i*3+j], tg.data[i*3+j])
+			}
+			return sum
+		}
+		y := activate(z)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 3; j++ {
+				hi := NewFloatMatrix(4, 3, append([]float64(nil), z.data...))
+				lo := NewFloatMatrix(4, 3, append([]float64(nil), z.data...))
+				hi.data[i*3+j] += h
+				lo.data[i*3+j] -= h
+				want := -(loss(hi, j) - loss(lo, j)) / (2 * h)
+				if got := tg.data[i*3+j] - y.data[i*3+j]; math.Abs(got-want) > 1e-6 {
+					t.Errorf("%v %v: (%d, %d) target - output = %v, minus the gradient %v", c.l, c.f, i, j, got, want)
+				}
+			}
+		}
+
+		for _, b := range []Backend{FixedPoint, Float64} {
+			out, targets := b.convert(y), b.convert(tg)
+			errs, err := c.l.errors(c.f, out, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := ToDense(targets)
+			od, _ := ToDense(out)
+			want.Sub(want, od)
+			if i, j, ok := closeTo(errs, want, 0); !ok {
+				t.Errorf("%v %v %v: errors (%d, %d) = %v, want target - output %v", b, c.l, c.f, i, j, errs.AtFloat(i, j), want.At(i, j))
+			}
+
+			// the loss gradient through the activation's derivative
+			grads, err := c.l.errors(ActivationLinear, out, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var long Mat
+			if c.f == ActivationSoftmax {
+				long, err = softmaxPrime(out, grads)
+			} else {
+				long = out.New(4, 3)
+				if err = long.ActivatePrime(c.f, out); err == nil {
+					err = long.MulElemErr(long, grads)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i, j, ok := closeTo(long, want, 1e-9); !ok {
+				t.Errorf("%v %v %v: through the derivative (%d, %d) = %v, want %v", b, c.l, c.f, i, j, long.AtFloat(i, j), want.At(i, j))
+			}
+
+			// and Dense.Backward trains on the errors as they are, and the
+			// long way round without o.Loss
+			d := &Dense{weights: b.convert(NewMatrix(4, 2, randomArray(8, 2))), bias: b.matrix(4, 1, nil), activation: c.f}
+			in := b.convert(NewMatrix(2, 3, randomArray(6, 1)))
+			for _, o := range []Options{{Loss: c.l}, {Loss: LossMSE}} {
+				e := errs
+				if o.Loss == LossMSE {
+					e = grads
+				}
+				_, g, err := d.Backward(o, in, out, e, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bias := g[1]
+				for i := 0; i < 4; i++ {
+					var sum float64
+					for j := 0; j < 3; j++ {
+						sum += want.At(i, j)
+					}
+					if math.Abs(bias.AtFloat(i, 0)-sum) > 1e-9 {
+						t.Errorf("%v %v %v loss %v: bias gradient %d = %v, want %v", b, c.l, c.f, o.Loss, i, bias.AtFloat(i, 0), sum)
+					}
+				}
+			}
+		}
+	}
+}
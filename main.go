@@ -13,9 +13,11 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/vardius/progress-go"
 	"log"
+	"math"
 	"gonum.org/v1/plot"
     "gonum.org/v1/plot/plotter"
     "gonum.org/v1/plot/vg"
@@ -23,16 +25,118 @@ import (
 
 func main() {
 	// 784 inputs - 28 x 28 pixels, each pixel is an input
-	// 100 hidden nodes - an arbitrary number
+	// 200 hidden nodes - an arbitrary number, in as many layers as -hidden
+	// lists
 	// 10 outputs - digits 0 to 9
-	// 0.1 is the learning rate
-	net := CreateNetwork(784, 200, 10, fixed(0x199999999999))
-
 	numbers := flag.String("numbers", "", "Either train or predict to evaluate neural network using mnist numbers dataset")
 	fashion := flag.String("fashion", "", "Either train or predict to evaluate neural network using mnist fashion dataset")
 	file := flag.String("file", "", "File name of 28 x 28 PNG file to evaluate")
+	qformat := flag.String("format", Q16_48.String(), "Fixed point format to run in, e.g. Q16.48, Q8.24 or Q4.12")
+	saturate := flag.Bool("saturate", false, "Clamp results that overflow the format instead of wrapping them")
+	round := flag.String("rounding", RoundHalfEven.String(), "Rounding of products: truncate, half-up or half-even")
+	squash := flag.String("sigmoid", SquashExact.String(), "Sigmoid implementation: exact, table, plan or hard")
+	accumulator := flag.String("accumulator", Narrow.String(), "Dot product accumulator: narrow (round every product) or wide (round the exact sum once)")
+	nworkers := flag.Int("workers", workers, "Number of goroutines matrix products are split between")
+	backend := flag.String("backend", FixedPoint.String(), "Matrix implementation to compute with: fixed or float (float64 reference)")
+	hidden := flag.String("hidden", "200", "Comma separated sizes of the hidden layers, e.g. 256,128")
+	activations := flag.String("activations", ActivationSigmoid.String(), "Comma separated activation of each layer after the inputs, or one for all of them: sigmoid, relu, leaky-relu, elu, tanh, hard-tanh, linear, softplus or softmax")
+	loss := flag.String("loss", LossMSE.String(), "Loss to train with: mse, mae, huber, bce (binary cross-entropy) or cce (categorical cross-entropy, for a softmax output layer)")
+	prune := flag.Float64("prune", 0, "Drop weights of at most this magnitude and predict with sparse weights")
 	flag.Parse()
 
+	SetWorkers(*nworkers)
+	if *saturate {
+		SetArithmetic(Saturate)
+	}
+	switch *round {
+	case Truncate.String():
+		SetRounding(Truncate)
+	case RoundHalfUp.String():
+		SetRounding(RoundHalfUp)
+	case RoundHalfEven.String():
+		SetRounding(RoundHalfEven)
+	default:
+		log.Fatalf("unknown rounding %q", *round)
+	}
+
+	f, err := ParseFormat(*qformat)
+	if err == nil {
+		err = SetFormat(f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sizes := []int{784}
+	for _, s := range strings.Split(*hidden, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			log.Fatalf("bad hidden layer size %q", s)
+		}
+		sizes = append(sizes, n)
+	}
+	sizes = append(sizes, 10)
+
+	// 0.1 is the learning rate
+	net := NewNetwork(floatToFixed(0.1), sizes...)
+	if s, ok := ParseSquash(*squash); ok {
+		net.SetSquash(s)
+	} else {
+		log.Fatalf("unknown sigmoid %q", *squash)
+	}
+	if a, ok := ParseAccumulator(*accumulator); ok {
+		net.SetAccumulator(a)
+	} else {
+		log.Fatalf("unknown accumulator %q", *accumulator)
+	}
+	names := strings.Split(*activations, ",")
+	if len(names) != 1 && len(names) != len(net.Layers()) {
+		log.Fatalf("%d activations for %d layers", len(names), len(net.Layers()))
+	}
+	for i := range net.Layers() {
+		name := strings.TrimSpace(names[0])
+		if len(names) > 1 {
+			name = strings.TrimSpace(names[i])
+		}
+		f, ok := ParseActivation(name)
+		if !ok {
+			log.Fatalf("unknown activation %q", name)
+		}
+		net.SetActivation(i, f)
+	}
+	if l, ok := ParseLoss(*loss); ok {
+		net.SetLoss(l)
+	} else {
+		log.Fatalf("unknown loss %q", *loss)
+	}
+	if b, ok := ParseBackend(*backend); ok {
+		net.SetBackend(b)
+	} else {
+		log.Fatalf("unknown backend %q", *backend)
+	}
+
+	// loadModel loads the stored network, stopping if it does not fit the
+	// network the flags describe
+	loadModel := func(dataset string) {
+		if err := loadErr(&net, dataset); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// loadPruned loads the stored network and prunes it if -prune is set
+	loadPruned := func(dataset string) {
+		loadModel(dataset)
+		if *prune > 0 {
+			net.Prune(floatToFixed(*prune))
+			for i, l := range net.Layers() {
+				if d, ok := l.(*Dense); ok && d.sparse != nil {
+					in, out := d.Dims()
+					fmt.Printf("layer %d pruned to %d of %d weights\n", i+1, d.sparse.NNZ(), in*out)
+				}
+			}
+		}
+	}
+
 	// train or mass predict to determine the effectiveness of the trained network
 	switch *numbers {
 	case "train":
@@ -40,12 +144,17 @@ func main() {
 	case "plot":
 		mnistTrainForPlot(&net, "numbers")
 	case "predict":
-		load(&net, "numbers")
+		loadPruned("numbers")
 		mnistPredict(&net, "numbers")
+	case "accumulate":
+		loadModel("numbers")
+		compareAccumulators(&net, "numbers")
 	case "val":
 		generateValidation("numbers")
 	case "activation":
 		showActivation()
+	case "bench":
+		benchmarkProduct(&net)
 	default:
 		// don't do anything
 	}
@@ -56,8 +165,11 @@ func main() {
 	case "plot":
 		mnistTrainForPlot(&net, "fashion")
 	case "predict":
-		load(&net, "fashion")
+		loadPruned("fashion")
 		mnistPredict(&net, "fashion")
+	case "accumulate":
+		loadModel("fashion")
+		compareAccumulators(&net, "fashion")
 	case "val":
 		generateValidation("fashion")
 	default:
@@ -69,7 +181,7 @@ func main() {
 		// print the image out nicely on the terminal
 		printImage(getImage(*file))
 		// load the neural network from file
-		load(&net, "numbers")
+		loadPruned("numbers")
 		// predict which number it is
 		fmt.Println("prediction:", predictFromImage(net, *file))
 	}
@@ -79,11 +191,16 @@ func showActivation() {
 	t1 := time.Now()
     p := plot.New()
 
-    sig := plotter.NewFunction(func(x float64) float64 { return toFloat(sigmoid(0, 0, floatToFixed(x)))})
-    sig.Samples = 2048
+    for s := SquashExact; s < numSquashes; s++ {
+        fn := s.fn()
+        sig := plotter.NewFunction(func(x float64) float64 { return toFloat(fn(0, 0, floatToFixed(x)))})
+        sig.Samples = 2048
+        p.Add(sig)
+        max, mean := SquashError(s)
+        fmt.Printf("%-6s max error %v mean error %v\n", s, max, mean)
+    }
 
     p.Title.Text = "sigmoid plot"
-    p.Add(sig)
 
     p.X.Min = -64
 	p.X.Max = 64
@@ -97,6 +214,77 @@ func showActivation() {
 	fmt.Printf("\nTime taken to generate activation image: %s\n", elapsed)
 }
 
+// benchmarkProduct times the products Train does on the first hidden layer,
+// with the naive triple loop, the tiled product on one goroutine and the
+// tiled product on every worker, and then a whole Train step.
+func benchmarkProduct(net *Network) {
+	const rounds = 20
+	weights := AsMatrix(net.Layers()[0].Params()[0])
+	hidden, input := weights.Dims()
+	inputs := NewMatrix(input, 1, randomArray(input, 1))
+	deltas := NewMatrix(hidden, 1, randomArray(hidden, 1))
+	batch := NewMatrix(input, 32, randomArray(input*32, 1))
+
+	cases := []struct {
+		name string
+		a, b *Matrix
+		ta, tb bool
+	}{
+		{"W.x", weights, inputs, false, false},
+		{"W.X (batch of 32)", weights, batch, false, false},
+		{"Wt.d", weights, deltas, true, false},
+		{"d.xt", deltas, inputs, false, true},
+	}
+	n := workers
+	fmt.Printf("%-18s %14s %14s %14s\n", "product", "naive", "tiled x1", fmt.Sprintf("tiled x%d", n))
+	for _, c := range cases {
+		a, b := c.a, c.b
+		if c.ta {
+			a = a.T()
+		}
+		if c.tb {
+			b = b.T()
+		}
+		r, _ := a.Dims()
+		_, col := b.Dims()
+		want := NewMatrix(r, col, nil)
+		got := NewMatrix(r, col, nil)
+
+		t1 := time.Now()
+		for i := 0; i < rounds; i++ {
+			want.productNaive(a, b)
+		}
+		naive := time.Since(t1) / rounds
+
+		var tiled [2]time.Duration
+		for w, nw := range []int{1, n} {
+			SetWorkers(nw)
+			t1 = time.Now()
+			for i := 0; i < rounds; i++ {
+				got.gemm(c.name, Narrow, c.a, c.ta, c.b, c.tb)
+			}
+			tiled[w] = time.Since(t1) / rounds
+		}
+		for i := 0; i < r; i++ {
+			for j := 0; j < col; j++ {
+				if got.At(i, j) != want.At(i, j) {
+					log.Fatalf("%s: tiled product differs at (%d, %d)", c.name, i, j)
+				}
+			}
+		}
+		fmt.Printf("%-18s %14s %14s %14s\n", c.name, naive, tiled[0], tiled[1])
+	}
+
+	targets := randomArray(net.outputs, 1)
+	t1 := time.Now()
+	for i := 0; i < rounds; i++ {
+		if _, err := net.Train(inputs.RawMatrix().Data, targets); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("\nTrain step with %d workers: %s\n", n, time.Since(t1) / rounds)
+}
+
 func generateValidation(dataset string) {
 	t1 := time.Now()
 	var testFile *os.File
@@ -140,6 +328,7 @@ func generateValidation(dataset string) {
 func mnistTrain(net *Network, dataset string) {
 	rand.Seed(time.Now().UTC().UnixNano())
 	t1 := time.Now()
+	ResetCounters()
 	var testFile *os.File
 	bar := progress.New(0, 5)
 	_, _ = bar.Start()
@@ -158,6 +347,8 @@ func mnistTrain(net *Network, dataset string) {
 				testFile, _ = os.Open("mnist_dataset/mnist_train.csv")
 		}
 		r := csv.NewReader(bufio.NewReader(testFile))
+		var cost float64
+		records := 0
 		for {
 			record, err := r.Read()
 			if err == io.EOF {
@@ -170,25 +361,46 @@ func mnistTrain(net *Network, dataset string) {
 				inputs[i] = floatToFixed((x / 255.0 * 0.999) + 0.001)
 			}
 
-			targets := make([]fixed, 10)
-			for i := range targets {
-				targets[i] = fixed(0x004189374bc7)
-			}
 			x, _ := strconv.Atoi(record[0])
-			targets[x] = fixed(0xffbe76c8b439)
+			targets := mnistTargets(net, x)
 
-			net.Train(inputs, targets)
+			// Train only fails on a network that does not fit the data,
+			// which every other record would fail on too
+			c, err := net.Train(inputs, targets)
+			if err != nil {
+				log.Fatalf("epoch %d record %d: %v", epochs, records+1, err)
+			}
+			cost += toFloat(c)
+			records++
 		}
 		testFile.Close()
 		_, _ = bar.Advance(1)
+		fmt.Printf("\nepoch %d mean %v loss: %.6g\n", epochs, net.loss, cost/float64(records))
 	}
 	bar.Stop()
 	save(*net, dataset)
 	elapsed := time.Since(t1)
 	fmt.Printf("\nTime taken to train: %s\n", elapsed)
+	fmt.Printf("%s overflow/underflow: %v\n", arithmetic, ReadCounters())
 	mnistPredict(net, dataset)
 }
 
+// mnistTargets returns the targets for label: one-hot for cross-entropy,
+// whose targets must sum to one, and otherwise 0.001 and 0.999 so a sigmoid
+// output can reach them
+func mnistTargets(net *Network, label int) []fixed {
+	lo, hi := floatToFixed(0.001), floatToFixed(0.999)
+	if net.loss == LossCCE {
+		lo, hi = 0, ONE
+	}
+	targets := make([]fixed, net.outputs)
+	for i := range targets {
+		targets[i] = lo
+	}
+	targets[label] = hi
+	return targets
+}
+
 func mnistTrainForPlot(net *Network, dataset string) {
 	rand.Seed(time.Now().UTC().UnixNano())
 	t1 := time.Now()
@@ -225,14 +437,12 @@ func mnistTrainForPlot(net *Network, dataset string) {
 				inputs[i] = floatToFixed((x / 255.0 * 0.999) + 0.001)
 			}
 
-			targets := make([]fixed, 10)
-			for i := range targets {
-				targets[i] = fixed(0x004189374bc7)
-			}
 			x, _ := strconv.Atoi(record[0])
-			targets[x] = fixed(0xffbe76c8b439)
+			targets := mnistTargets(net, x)
 
-			net.Train(inputs, targets)
+			if _, err := net.Train(inputs, targets); err != nil {
+				log.Fatalf("record %d: %v", count, err)
+			}
 			if(count % 1000 == 0){
 				switch dataset {
 					case "numbers":
@@ -259,24 +469,20 @@ func mnistTrainForPlot(net *Network, dataset string) {
 						inputs[i] = floatToFixed((x / 255.0 * 0.999) + 0.001)
 					}
 					outputs := net.Predict(inputs)
-					best := 0
-					highest := fixed(0)
-					for i := 0; i < net.outputs; i++ {
-						if outputs.At(i, 0) > highest {
-							best = i
-							highest = outputs.At(i, 0)
-						}
-					}
+					best, _ := ArgMax(AsMatrix(outputs))
 					target, _ := strconv.Atoi(record[0])
 					if best == target {
 						score++
 					}
 				}
 				net.score = score
-				net.hidden_max = Max(net.hiddenWeights)
-				net.hidden_min = Min(net.hiddenWeights)
-				net.out_max = Max(net.outputWeights)
-				net.out_min = Min(net.outputWeights)
+				layers := net.Layers()
+				first := AsMatrix(layers[0].Params()[0])
+				last := AsMatrix(layers[len(layers)-1].Params()[0])
+				net.hidden_max = Max(first)
+				net.hidden_min = Min(first)
+				net.out_max = Max(last)
+				net.out_min = Min(last)
 				value = append(value, []string{strconv.Itoa(epochs), strconv.Itoa(count), strconv.FormatFloat(toFloat(net.hidden_max), 'f', -1, 64), strconv.FormatFloat(toFloat(net.hidden_min), 'f', -1, 64), strconv.FormatFloat(toFloat(net.hidden_max - net.hidden_min), 'f', -1, 64), strconv.FormatFloat(toFloat(net.out_max), 'f', -1, 64), strconv.FormatFloat(toFloat(net.out_min), 'f', -1, 64), strconv.FormatFloat(toFloat(net.out_max - net.out_min), 'f', -1, 64), strconv.Itoa(net.score),})
 				checkFile.Close()
 			}
@@ -295,6 +501,7 @@ func mnistTrainForPlot(net *Network, dataset string) {
 
 func mnistPredict(net *Network, dataset string) {
 	t1 := time.Now()
+	ResetCounters()
 	var checkFile *os.File
 	switch dataset {
 			case "numbers":
@@ -321,14 +528,7 @@ func mnistPredict(net *Network, dataset string) {
 			inputs[i] = floatToFixed((x / 255.0 * 0.999) + 0.001)
 		}
 		outputs := net.Predict(inputs)
-		best := 0
-		highest := fixed(0)
-		for i := 0; i < net.outputs; i++ {
-			if outputs.At(i, 0) > highest {
-				best = i
-				highest = outputs.At(i, 0)
-			}
-		}
+		best, _ := ArgMax(AsMatrix(outputs))
 		target, _ := strconv.Atoi(record[0])
 		if best == target {
 			score++
@@ -338,6 +538,79 @@ func mnistPredict(net *Network, dataset string) {
 	elapsed := time.Since(t1)
 	fmt.Printf("Time taken to check: %s\n", elapsed)
 	fmt.Println("score:", score)
+	fmt.Printf("%s overflow/underflow: %v\n", arithmetic, ReadCounters())
+}
+
+// compareAccumulators runs the test set through the stored model with each
+// Accumulator and reports the score, the error of the outputs against the
+// same network run on the Float64 backend, and how many predictions differ.
+func compareAccumulators(net *Network, dataset string) {
+	net.SetBackend(FixedPoint)
+	ref := *net
+	ref.SetBackend(Float64)
+	t1 := time.Now()
+	var checkFile *os.File
+	switch dataset {
+			case "numbers":
+				checkFile, _ = os.Open("mnist_dataset/mnist_test.csv")
+			case "fashion":
+				checkFile, _ = os.Open("mnist_dataset/fashion_mnist_test.csv")
+			default:
+				checkFile, _ = os.Open("mnist_dataset/mnist_test.csv")
+	}
+	defer checkFile.Close()
+
+	var score [numAccumulators]int
+	var maxErr, sumErr [numAccumulators]float64
+	var counts [numAccumulators]Counters
+	differ, records := 0, 0
+	r := csv.NewReader(bufio.NewReader(checkFile))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		inputs := make([]fixed, net.inputs)
+		for i := range inputs {
+			x, _ := strconv.ParseFloat(record[i], 64)
+			inputs[i] = floatToFixed((x / 255.0 * 0.999) + 0.001)
+		}
+		target, _ := strconv.Atoi(record[0])
+		want := ref.Predict(inputs)
+
+		var best [numAccumulators]int
+		for a := Narrow; a < numAccumulators; a++ {
+			n := *net
+			n.SetAccumulator(a)
+			ResetCounters()
+			outputs := n.Predict(inputs)
+			c := ReadCounters()
+			for i := range counts[a] {
+				counts[a][i].Overflow += c[i].Overflow
+				counts[a][i].Underflow += c[i].Underflow
+			}
+			best[a], _ = ArgMax(AsMatrix(outputs))
+			for i := 0; i < net.outputs; i++ {
+				e := math.Abs(outputs.AtFloat(i, 0) - want.AtFloat(i, 0))
+				maxErr[a] = math.Max(maxErr[a], e)
+				sumErr[a] += e
+			}
+			if best[a] == target {
+				score[a]++
+			}
+		}
+		if best[Narrow] != best[Wide] {
+			differ++
+		}
+		records++
+	}
+
+	fmt.Printf("Time taken to compare: %s\n", time.Since(t1))
+	for a := Narrow; a < numAccumulators; a++ {
+		fmt.Printf("%-6s score %d, output error against float64: max %.3g mean %.3g, %s overflow/underflow: %v\n",
+			a, score[a], maxErr[a], sumErr[a]/float64(records*net.outputs), arithmetic, counts[a])
+	}
+	fmt.Printf("predictions that differ: %d of %d\n", differ, records)
 }
 
 // print out image on iTerm2; equivalent to imgcat on iTerm2
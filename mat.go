@@ -0,0 +1,120 @@
+package main
+
+// Mat is the matrix a Network computes with. *Matrix is the fixed point
+// implementation and *FloatMatrix the float64 reference, so the same Network
+// code runs on either. Elements cross the interface as fixed; AtFloat reads
+// them without rounding. Like *Matrix, the methods write their result to the
+// receiver, and operands of another implementation are converted to the
+// receiver's first.
+type Mat interface {
+	Multiplier
+	At(r, c int) fixed
+	Set(r, c int, v fixed)
+	AtFloat(r, c int) float64
+	// New returns a zeroed r x c matrix of the same implementation
+	New(r, c int) Mat
+
+	ProductErr(a, b Mat) error
+	ProductWith(acc Accumulator, a Mat, ta bool, b Mat, tb bool) error
+	MulElemErr(a, b Mat) error
+	AddErr(a, b Mat) error
+	SubErr(a, b Mat) error
+	AddBroadcastErr(a, b Mat) error
+	SubBroadcastErr(a, b Mat) error
+	// SumAlongErr sets the receiver to the sums of every row or every
+	// column of a
+	SumAlongErr(a Mat, axis Axis) error
+	ApplyErr(fn func(i, j int, v fixed) fixed, a Mat) error
+	Scale(c fixed)
+	// Activate sets the receiver to f of a, computing the sigmoid as s
+	// selects
+	Activate(f Activation, s Squash, a Mat) error
+	// ActivatePrime sets the receiver to the derivative of f at the inputs
+	// for which f gave a
+	ActivatePrime(f Activation, a Mat) error
+}
+
+// Multiplier is the left operand of a product with a dense matrix: a Mat,
+// or a *Sparse for pruned weights.
+type Multiplier interface {
+	Dims() (r, c int)
+	// ProductInto sets dst to the receiver times b, summing with acc
+	ProductInto(dst Mat, acc Accumulator, b Mat) error
+}
+
+// AsMatrix returns m if it is a *Matrix, and otherwise a fixed point copy
+// of it, rounded with the package rounding mode.
+func AsMatrix(m Mat) *Matrix {
+	if f, ok := m.(*Matrix); ok {
+		return f
+	}
+	r, c := m.Dims()
+	o := NewMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			o.set(i, j, m.At(i, j))
+		}
+	}
+	return o
+}
+
+// AsFloatMatrix returns m if it is a *FloatMatrix, and otherwise a float64
+// copy of it, which is exact for a *Matrix.
+func AsFloatMatrix(m Mat) *FloatMatrix {
+	if f, ok := m.(*FloatMatrix); ok {
+		return f
+	}
+	r, c := m.Dims()
+	o := NewFloatMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			o.data[i*c+j] = m.AtFloat(i, j)
+		}
+	}
+	return o
+}
+
+// Backend selects the Mat implementation a Network computes with.
+type Backend int
+
+const (
+	// FixedPoint computes with *Matrix in the active format
+	FixedPoint Backend = iota
+	// Float64 computes with *FloatMatrix, as a reference
+	Float64
+	numBackends
+)
+
+var backendNames = [numBackends]string{"fixed", "float"}
+
+func (b Backend) String() string {
+	return backendNames[b]
+}
+
+// ParseBackend is the inverse of Backend.String.
+func ParseBackend(name string) (Backend, bool) {
+	for b, n := range backendNames {
+		if n == name {
+			return Backend(b), true
+		}
+	}
+	return FixedPoint, false
+}
+
+// matrix returns an r x c matrix of b holding data, which it may adopt as
+// its storage.
+func (b Backend) matrix(r, c int, data []fixed) Mat {
+	m := NewMatrix(r, c, data)
+	if b == Float64 {
+		return AsFloatMatrix(m)
+	}
+	return m
+}
+
+// convert returns m as a matrix of b.
+func (b Backend) convert(m Mat) Mat {
+	if b == Float64 {
+		return AsFloatMatrix(m)
+	}
+	return AsMatrix(m)
+}
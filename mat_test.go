@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// floatOperand returns an r x c matrix of uniform values in (-2, 2), exact
+// in either backend.
+func floatOperand(rng *rand.Rand, r, c int) *Matrix {
+	m := NewMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.Set(i, j, floatToFixed(rng.Float64()*4-2))
+		}
+	}
+	return m
+}
+
+// TestBackendParity checks every Mat method of the float64 backend against
+// the fixed point one, in Q16.48, where they only differ by rounding.
+func TestBackendParity(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	const tol = 1e-12
+	x, y := floatOperand(rng, 4, 3), floatOperand(rng, 4, 3)
+	col, row := floatOperand(rng, 4, 1), floatOperand(rng, 1, 3)
+	sq := floatOperand(rng, 3, 3)
+	type parityCase struct {
+		name string
+		r, c int
+		fn   func(m, a, b Mat) error
+		a, b *Matrix
+	}
+	cases := []parityCase{
+		{"Product", 4, 3, func(m, a, b Mat) error { return m.ProductErr(a, b) }, x, sq},
+		{"ProductWith ta", 3, 3, func(m, a, b Mat) error { return m.ProductWith(Narrow, a, true, b, false) }, x, y},
+		{"ProductWith tb", 4, 4, func(m, a, b Mat) error { return m.ProductWith(Wide, a, false, b, true) }, x, y},
+		{"ProductInto", 4, 3, func(m, a, b Mat) error { return a.ProductInto(m, Narrow, b) }, x, sq},
+		{"MulElem", 4, 3, func(m, a, b Mat) error { return m.MulElemErr(a, b) }, x, y},
+		{"Add", 4, 3, func(m, a, b Mat) error { return m.AddErr(a, b) }, x, y},
+		{"Sub", 4, 3, func(m, a, b Mat) error { return m.SubErr(a, b) }, x, y},
+		{"AddBroadcast column", 4, 3, func(m, a, b Mat) error { return m.AddBroadcastErr(a, b) }, x, col},
+		{"SubBroadcast row", 4, 3, func(m, a, b Mat) error { return m.SubBroadcastErr(a, b) }, x, row},
+		{"SumAlong rows", 4, 1, func(m, a, b Mat) error { return m.SumAlongErr(a, AlongRows) }, x, nil},
+		{"SumAlong cols", 1, 3, func(m, a, b Mat) error { return m.SumAlongErr(a, AlongCols) }, x, nil},
+		{"Apply", 4, 3, func(m, a, b Mat) error {
+			return m.ApplyErr(func(i, j int, v fixed) fixed { return v*fixed(i+1) - fixed(j)*ONE }, a)
+		}, x, nil},
+		{"Scale", 4, 3, func(m, a, b Mat) error {
+			if err := m.AddErr(a, a.New(4, 3)); err != nil {
+				return err
+			}
+			m.Scale(floatToFixed(-0.375))
+			return nil
+		}, x, nil},
+	}
+	for f := Activation(0); f < numActivations; f++ {
+		f := f
+		cases = append(cases, parityCase{f.String(), 4, 3, func(m, a, b Mat) error { return m.Activate(f, SquashExact, a) }, x, nil})
+		if f == ActivationSoftmax {
+			// not elementwise, so it has no ActivatePrime
+			continue
+		}
+		cases = append(cases, parityCase{f.String() + " prime", 4, 3, func(m, a, b Mat) error {
+			out := m.New(4, 3)
+			if err := out.Activate(f, SquashExact, a); err != nil {
+				return err
+			}
+			return m.ActivatePrime(f, out)
+		}, x, nil})
+	}
+
+	for _, c := range cases {
+		var results [numBackends]Mat
+		for b := Backend(0); b < numBackends; b++ {
+			m := b.matrix(c.r, c.c, nil)
+			var a, o Mat = b.convert(c.a), nil
+			if c.b != nil {
+				o = b.convert(c.b)
+			}
+			if err := c.fn(m, a, o); err != nil {
+				t.Fatalf("%s %v: %v", c.name, b, err)
+			}
+			results[b] = m
+		}
+		if _, ok := results[Float64].(*FloatMatrix); !ok {
+			t.Fatalf("%s: the float backend gave a %T", c.name, results[Float64])
+		}
+		want, _ := ToDense(results[Float64])
+		if i, j, ok := closeTo(results[FixedPoint], want, tol); !ok {
+			t.Errorf("%s: fixed (%d, %d) = %v, float %v", c.name, i, j, results[FixedPoint].AtFloat(i, j), want.At(i, j))
+		}
+	}
+}
+
+// TestNetworkBackendParity trains copies of a network on each backend with
+// the same samples and checks they predict the same to well within what
+// they are trained to.
+func TestNetworkBackendParity(t *testing.T) {
+	rng := rand.New(rand.NewSource(14))
+	for _, c := range []struct {
+		hidden, output Activation
+		loss           Loss
+	}{
+		{ActivationSigmoid, ActivationSigmoid, LossMSE},
+		{ActivationTanh, ActivationSoftmax, LossCCE},
+		{ActivationReLU, ActivationLinear, LossHuber},
+	} {
+		name := fmt.Sprintf("%v %v %v", c.hidden, c.output, c.loss)
+		net := NewNetwork(floatToFixed(0.1), 6, 5, 3)
+		net.SetActivation(0, c.hidden)
+		net.SetActivation(1, c.output)
+		net.SetLoss(c.loss)
+		float := net
+		float.SetBackend(Float64)
+
+		var inputs, targets [][]fixed
+		for i := 0; i < 20; i++ {
+			in := make([]fixed, 6)
+			for j := range in {
+				in[j] = floatToFixed(rng.Float64())
+			}
+			tg := make([]fixed, 3)
+			tg[rng.Intn(3)] = ONE
+			inputs, targets = append(inputs, in), append(targets, tg)
+		}
+		for epoch := 0; epoch < 5; epoch++ {
+			for i := range inputs {
+				fc, err := net.Train(inputs[i], targets[i])
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				flc, err := float.Train(inputs[i], targets[i])
+				if err != nil {
+					t.Fatalf("%s float: %v", name, err)
+				}
+				if math.Abs(toFloat(fc)-toFloat(flc)) > 1e-9 {
+					t.Fatalf("%s: epoch %d sample %d loss %v, float %v", name, epoch, i, toFloat(fc), toFloat(flc))
+				}
+			}
+		}
+		for i := range inputs {
+			want, _ := ToDense(float.Predict(inputs[i]))
+			got := net.Predict(inputs[i])
+			if _, ok := got.(*Matrix); !ok {
+				t.Fatalf("%s: the fixed backend predicted a %T", name, got)
+			}
+			if r, j, ok := closeTo(got, want, 1e-9); !ok {
+				t.Errorf("%s: sample %d output %d = %v, float %v", name, i, r+j, got.AtFloat(r, j), want.At(r, j))
+			}
+		}
+	}
+}
@@ -4,21 +4,26 @@ import (
 	"fmt"
 	"image"
 	"image/png"
-	"math"
 	"encoding/csv"
 	"os"
+	"strings"
 	"gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/gonum/mat"
 	"io"
 )
 
-// Network is a neural network with 3 layers
+// Network is a neural network of dense layers
 type Network struct {
 	inputs       	int
-	hiddens      	int
 	outputs      	int
 	learningRate 	fixed
-	hiddenWeights 	*Matrix
-	outputWeights 	*Matrix
+	squash			Squash
+	accumulator		Accumulator
+	backend			Backend
+	loss			Loss
+	// layers from the inputs to the outputs; Train replaces the slice
+	// rather than writing to it, so copies of a Network stay independent
+	layers			[]Layer
 	hidden_max		fixed
 	hidden_min		fixed
 	out_max			fixed
@@ -26,112 +31,251 @@ type Network struct {
 	score			int
 }
 
-// CreateNetwork creates a neural network with random weights
+// CreateNetwork creates a neural network with one hidden layer and random
+// weights
 func CreateNetwork(input, hidden, output int, rate fixed) (net Network) {
+	return NewNetwork(rate, input, hidden, output)
+}
+
+// NewNetwork creates a neural network of dense layers with random weights,
+// sizes running from the inputs through the hidden layers to the outputs
+func NewNetwork(rate fixed, sizes ...int) (net Network) {
+	if len(sizes) < 2 {
+		panic("NewNetwork: need the input and output sizes")
+	}
 	net = Network{
-		inputs:       input,
-		hiddens:      hidden,
-		outputs:      output,
+		inputs:       sizes[0],
+		outputs:      sizes[len(sizes)-1],
 		learningRate: rate,
 	}
-	net.hiddenWeights = NewMatrix(net.hiddens, net.inputs, randomArray(net.inputs*net.hiddens, float64(net.inputs)))
-	net.hidden_min = Min(net.hiddenWeights)
-	net.hidden_max = Max(net.hiddenWeights)
-	net.outputWeights = NewMatrix(net.outputs, net.hiddens, randomArray(net.hiddens*net.outputs, float64(net.hiddens)))
-	net.out_min = Min(net.outputWeights)
-	net.out_max = Max(net.outputWeights)
+	for i := 1; i < len(sizes); i++ {
+		net.layers = append(net.layers, NewDense(sizes[i-1], sizes[i]))
+	}
+	first := AsMatrix(net.layers[0].Params()[0])
+	net.hidden_min = Min(first)
+	net.hidden_max = Max(first)
+	last := AsMatrix(net.layers[len(net.layers)-1].Params()[0])
+	net.out_min = Min(last)
+	net.out_max = Max(last)
 
 	return
 }
 
-// Train the neural network
-func (net *Network) Train(inputData []fixed, targetData []fixed) {
-	// feedforward
-	inputs := NewMatrix(len(inputData), 1, inputData)
-	hiddenInputs := dot(net.hiddenWeights, inputs)
-	hiddenOutputs := apply(sigmoid, hiddenInputs)
-	finalInputs := dot(net.outputWeights, hiddenOutputs)
-	finalOutputs := apply(sigmoid, finalInputs)
+// Layers returns the layers of the network, from the inputs to the outputs
+func (net Network) Layers() []Layer {
+	return net.layers
+}
+
+// SetSquash selects the sigmoid implementation used by Train and Predict
+func (net *Network) SetSquash(s Squash) {
+	net.squash = s
+}
+
+// SetActivation selects the activation of layer i, counting from the layer
+// after the inputs; layers without one are left as they are
+func (net *Network) SetActivation(i int, f Activation) {
+	l, ok := net.layers[i].(activated)
+	if !ok {
+		return
+	}
+	layers := append([]Layer(nil), net.layers...)
+	layers[i] = l.WithActivation(f)
+	net.layers = layers
+}
 
-	// find errors
-	targets := NewMatrix(len(targetData), 1, targetData)
-	outputErrors := subtract(targets, finalOutputs)
-	hiddenErrors := dot(net.outputWeights.T(), outputErrors)
+// SetLoss selects the loss Train minimises
+func (net *Network) SetLoss(l Loss) {
+	net.loss = l
+}
 
-	copy := Copy(outputErrors)
-	copy.MulElem(copy, outputErrors)
-	//net.errors = math.Sqrt(mat.Sum(copy))
+// SetAccumulator selects how Train and Predict sum their dot products
+func (net *Network) SetAccumulator(a Accumulator) {
+	net.accumulator = a
+}
+
+// SetBackend selects the Mat implementation Train and Predict compute with,
+// converting the weights to it. Pruned weights stay pruned but are only
+// kept sparse in fixed point.
+func (net *Network) SetBackend(b Backend) {
+	net.backend = b
+	layers := make([]Layer, len(net.layers))
+	for i, l := range net.layers {
+		ps := l.Params()
+		for j, p := range ps {
+			ps[j] = b.convert(p)
+		}
+		layers[i] = l.WithParams(ps)
+	}
+	net.layers = layers
+}
 
-	// backpropagate
-	net.outputWeights = add(net.outputWeights,
-		scale(net.learningRate,
-			dot(multiply(outputErrors, sigmoidPrime(finalOutputs)),
-				hiddenOutputs.T())))
+func (net Network) options() Options {
+	return Options{Squash: net.squash, Accumulator: net.accumulator}
+}
 
-	net.hiddenWeights = add(net.hiddenWeights,
-		scale(net.learningRate,
-			dot(multiply(hiddenErrors, sigmoidPrime(hiddenOutputs)),
-				inputs.T())))
+// feedforward returns the input and the outputs of every layer
+func (net Network) feedforward(inputData []fixed) ([]Mat, error) {
+	acts := []Mat{net.backend.matrix(len(inputData), 1, inputData)}
+	for _, l := range net.layers {
+		out, err := l.Forward(net.options(), acts[len(acts)-1])
+		if err != nil {
+			return nil, err
+		}
+		acts = append(acts, out)
+	}
+	return acts, nil
+}
+
+// Train the neural network, returning the loss of its outputs before the
+// update. The weights are only updated if every step succeeds; an input or
+// target of the wrong length gives a *ShapeError.
+func (net *Network) Train(inputData []fixed, targetData []fixed) (fixed, error) {
+	acts, err := net.feedforward(inputData)
+	if err != nil {
+		return 0, err
+	}
+
+	// find errors; a layer without an activation is taken as linear
+	outputs := acts[len(acts)-1]
+	f := ActivationLinear
+	if a, ok := net.layers[len(net.layers)-1].(activated); ok {
+		f = a.Activation()
+	}
+	targets := net.backend.matrix(len(targetData), 1, targetData)
+	errs, err := net.loss.errors(f, outputs, targets)
+	if err != nil {
+		return 0, err
+	}
+	cost := net.loss.value(outputs, targets)
+
+	// backpropagate, every layer's errors from the weights before the update
+	layers := make([]Layer, len(net.layers))
+	for i := len(net.layers) - 1; i >= 0; i-- {
+		l := net.layers[i]
+		o := net.options()
+		if i == len(net.layers)-1 {
+			o.Loss = net.loss
+		}
+		inErrs, grads, err := l.Backward(o, acts[i], acts[i+1], errs, i > 0)
+		if err != nil {
+			return 0, err
+		}
+		ps := l.Params()
+		for j, g := range grads {
+			g.Scale(net.learningRate)
+			if ps[j], err = addErr(ps[j], g); err != nil {
+				return 0, err
+			}
+		}
+		layers[i] = l.WithParams(ps)
+		errs = inErrs
+	}
+	net.layers = layers
+	return cost, nil
+}
+
+// Prune zeroes every weight whose magnitude is at most threshold and has
+// Predict use sparse copies of the weights until the next Train.
+func (net *Network) Prune(threshold fixed) {
+	layers := make([]Layer, len(net.layers))
+	for i, l := range net.layers {
+		if d, ok := l.(*Dense); ok {
+			l = d.prune(threshold, net.backend)
+		}
+		layers[i] = l
+	}
+	net.layers = layers
 }
 
 // Predict uses the neural network to predict the value given input data
-func (net Network) Predict(inputData []fixed) Matrix {
-	// feedforward
-	inputs := NewMatrix(len(inputData), 1, inputData)
-	hiddenInputs := dot(net.hiddenWeights, inputs)
-	hiddenOutputs := apply(sigmoid, hiddenInputs)
-	finalInputs := dot(net.outputWeights, hiddenOutputs)
-	finalOutputs := apply(sigmoid, finalInputs)
-	return *finalOutputs
+func (net Network) Predict(inputData []fixed) Mat {
+	outputs, err := net.PredictErr(inputData)
+	if err != nil {
+		panic(err)
+	}
+	return outputs
+}
+
+// PredictErr is Predict, returning a *ShapeError for an input of the wrong
+// length instead of panicking.
+func (net Network) PredictErr(inputData []fixed) (Mat, error) {
+	acts, err := net.feedforward(inputData)
+	if err != nil {
+		return nil, err
+	}
+	return acts[len(acts)-1], nil
 }
 
 func sigmoid(r, c int, z fixed) fixed {
-	if(z < -fixed(0xA000000000000)){
+	if(z < -sigmoidCutoff){
 		return fixed(0)
 	}
+	if(z < 0){
+		// e^z/(1 + e^z), so exp cannot overflow narrow formats
+		e := exp(z)
+		return DivideFixed(e, ONE + e)
+	}
 	return DivideFixed(ONE, ONE + exp(-z))
 }
 
-func sigmoidPrime(m *Matrix) *Matrix {
-	rows, _ := m.Dims()
-	o := make([]fixed, rows)
-	for i := range o {
-		o[i] = ONE
+// activate is f of m
+func activate(f Activation, s Squash, m Mat) Mat {
+	r, c := m.Dims()
+	o := m.New(r, c)
+	if err := o.Activate(f, s, m); err != nil {
+		panic(err)
 	}
-	ones := NewMatrix(rows, 1, o)
-	return multiply(m, subtract(ones, m)) // m * (1 - m)
+	return o
 }
 
-func relu(r, c int, z fixed) fixed {
-	if z>0 {
-		return z
+// activatePrime is the derivative of f given its outputs m
+func activatePrime(f Activation, m Mat) Mat {
+	r, c := m.Dims()
+	o := m.New(r, c)
+	if err := o.ActivatePrime(f, m); err != nil {
+		panic(err)
 	}
-	return 0
+	return o
 }
 
-func relup(r, c int, z fixed) fixed {
-	if z>0 {
-		return 1
+func dot(m, n Mat) Mat {
+	o, err := dotErr(Narrow, m, n)
+	if err != nil {
+		panic(err)
 	}
-	return 0
+	return o
 }
 
-func reluPrime(m *Matrix) *Matrix {
-	return apply(relup, m)
+// dotErr is m.n, the result being the same Mat implementation as n
+func dotErr(acc Accumulator, m Multiplier, n Mat) (Mat, error) {
+	r, _ := m.Dims()
+	_, c := n.Dims()
+	o := n.New(r, c)
+	return o, m.ProductInto(o, acc, n)
 }
 
-func dot(m, n *Matrix) *Matrix {
-	r, _ := m.Dims()
+// dotTransAErr is dot(m^T, n)
+func dotTransAErr(acc Accumulator, m, n Mat) (Mat, error) {
+	_, r := m.Dims()
 	_, c := n.Dims()
-	o := NewMatrix(r, c, nil)
-	o.Product(m, n)
-	return o
+	o := m.New(r, c)
+	return o, o.ProductWith(acc, m, true, n, false)
 }
 
-func apply(fn func(i, j int, v fixed) fixed, m *Matrix) *Matrix {
+// dotTransBErr is dot(m, n^T)
+func dotTransBErr(acc Accumulator, m, n Mat) (Mat, error) {
+	r, _ := m.Dims()
+	c, _ := n.Dims()
+	o := m.New(r, c)
+	return o, o.ProductWith(acc, m, false, n, true)
+}
+
+func apply(fn func(i, j int, v fixed) fixed, m Mat) Mat {
 	r, c := m.Dims()
-	o := NewMatrix(r, c, nil)
-	o.Apply(fn, m)
+	o := m.New(r, c)
+	if err := o.ApplyErr(fn, m); err != nil {
+		panic(err)
+	}
 	return o
 }
 
@@ -141,99 +285,142 @@ func scale(s fixed, m *Matrix) *Matrix {
 	return o
 }
 
-func multiply(m, n *Matrix) *Matrix {
-	r, c := m.Dims()
-	o := NewMatrix(r, c, nil)
-	o.MulElem(m, n)
+func multiply(m, n Mat) Mat {
+	o, err := multiplyErr(m, n)
+	if err != nil {
+		panic(err)
+	}
 	return o
 }
 
-func add(m, n *Matrix) *Matrix {
+func multiplyErr(m, n Mat) (Mat, error) {
 	r, c := m.Dims()
-	o := NewMatrix(r, c, nil)
-	o.Add(m, n)
+	o := m.New(r, c)
+	return o, o.MulElemErr(m, n)
+}
+
+func add(m, n Mat) Mat {
+	o, err := addErr(m, n)
+	if err != nil {
+		panic(err)
+	}
 	return o
 }
 
-func addScalar(i fixed, m *Matrix) *Matrix {
+func addErr(m, n Mat) (Mat, error) {
 	r, c := m.Dims()
-	a := make([]fixed, r*c)
-	for x := 0; x < r*c; x++ {
-		a[x] = i
-	}
-	n := NewMatrix(r, c, a)
-	return add(m, n)
+	o := m.New(r, c)
+	return o, o.AddErr(m, n)
 }
 
-func subtract(m, n *Matrix) *Matrix {
+func addScalar(i fixed, m *Matrix) *Matrix {
 	r, c := m.Dims()
 	o := NewMatrix(r, c, nil)
-	o.Sub(m, n)
+	o.AddBroadcast(m, Scalar(i))
 	return o
 }
 
-// randomly generate a float64 array
-func randomArray(size int, v float64) (data []fixed) {
+func subtract(m, n Mat) Mat {
+	o, err := subtractErr(m, n)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func subtractErr(m, n Mat) (Mat, error) {
+	r, c := m.Dims()
+	o := m.New(r, c)
+	return o, o.SubErr(m, n)
+}
+
+// randomly generate an array uniform in +-1/sqrt(v), v being the fan-in
+func randomArray(size int, v int) (data []fixed) {
 	dist := distuv.Uniform{
-		Min: -1 / math.Sqrt(v),
-		Max: 1 / math.Sqrt(v),
+		Min: -1,
+		Max: 1,
 	}
+	limit := rsqrtN(v)
 
 	data = make([]fixed, size)
 	for i := 0; i < size; i++ {
 		// data[i] = rand.NormFloat64() * math.Pow(v, -0.5)
-		data[i] = floatToFixed(dist.Rand())
+		data[i] = MultiplyFixed(floatToFixed(dist.Rand()), limit)
 	}
 	return
 }
 
-func addBiasNodeTo(m *Matrix, b fixed) *Matrix {
-	r, _ := m.Dims()
-	a := NewMatrix(r+1, 1, nil)
+// pretty print a matrix with gonum's formatter
+func matrixPrint(X Mat) {
+	d, _ := ToDense(X)
+	fa := mat.Formatted(d, mat.Prefix(""), mat.Squeeze())
+	fmt.Printf("%v\n", fa)
+}
 
-	a.Set(0, 0, b)
-	for i := 0; i < r; i++ {
-		a.Set(i+1, 0, m.At(i, 0))
+// modelDataset is the dataset the files of a model are named after
+func modelDataset(dataset string) string {
+	switch dataset {
+			case "numbers", "fashion":
+				return dataset
+			default:
+				return "numbers"
 	}
-	return a
 }
 
-// pretty print a Gonum matrix
-/*func matrixPrint(X Matrix) {
-	fa := mat.Formatted(X, mat.Prefix(""), mat.Squeeze())
-	fmt.Printf("%v\n", fa)
-}*/
+// activationsFile lists the activation of every layer, one per line
+func activationsFile(dataset string) string {
+	return "data/" + modelDataset(dataset) + "_activations.model"
+}
 
-func save(net Network, dataset string) {
-	var oweights string
-	var hweights string
-	switch dataset {
-			case "numbers":
-				hweights = "data/numbers_hweights.model"
-				oweights = "data/numbers_oweights.model"
-			case "fashion":
-				hweights = "data/fashion_hweights.model"
-				oweights = "data/fashion_oweights.model"
-			default:
-				hweights = "data/numbers_hweights.model"
-				oweights = "data/numbers_oweights.model"
+// paramNames are what the files of the Params of a layer are called
+var paramNames = []string{"weights", "bias"}
+
+// modelFiles returns the files the params of each of n layers are saved in:
+// the first hidden layer's weights in hweights and bias in hbias, the output
+// layer's in oweights and obias, and further hidden layers' in h2weights,
+// h2bias, h3weights and so on
+func modelFiles(dataset string, n int) [][]string {
+	dataset = modelDataset(dataset)
+	files := make([][]string, n)
+	for i := range files {
+		layer := fmt.Sprintf("h%d", i+1)
+		switch {
+		case i == n-1:
+			layer = "o"
+		case i == 0:
+			layer = "h"
+		}
+		for _, p := range paramNames {
+			files[i] = append(files[i], "data/"+dataset+"_"+layer+p+".model")
+		}
 	}
-	h, err := os.Create(hweights)
-	o, err2 := os.Create(oweights)
-	defer h.Close()
-	defer o.Close()
-	if err == nil {
-		r, err := net.hiddenWeights.MarshalBinaryTo()
-		if err == nil {
-    		_, err = io.Copy(h, r)
-  		}
+	return files
+}
+
+func save(net Network, dataset string) {
+	files := modelFiles(dataset, len(net.layers))
+	for i, l := range net.layers {
+		for j, p := range l.Params() {
+			f, err := os.Create(files[i][j])
+			if err != nil {
+				continue
+			}
+			r, err := AsMatrix(p).MarshalBinaryTo()
+			if err == nil {
+				_, err = io.Copy(f, r)
+			}
+			f.Close()
+		}
 	}
-	if err2 == nil {
-		r, err := net.outputWeights.MarshalBinaryTo()
-		if err == nil {
-    		_, err = io.Copy(o, r)
-  		}
+	var names []string
+	for _, l := range net.layers {
+		name := ""
+		if a, ok := l.(activated); ok {
+			name = a.Activation().String()
+		}
+		names = append(names, name)
 	}
+	os.WriteFile(activationsFile(dataset), []byte(strings.Join(names, "\n") + "\n"), 0644)
 }
 
 func save_plot(net Network, dataset string, value [][]string) {
@@ -252,32 +439,55 @@ func save_plot(net Network, dataset string, value [][]string) {
     w.WriteAll(value)
 }
 
-// load a neural network from file
+// load a neural network from file; a param whose file is missing keeps its
+// value, so models saved before layers had biases load with zero biases,
+// and models saved before activations were recorded with the activations
+// already set
 func load(net *Network, dataset string) {
-	var oweights string
-	var hweights string
-	switch dataset {
-			case "numbers":
-				hweights = "data/numbers_hweights.model"
-				oweights = "data/numbers_oweights.model"
-			case "fashion":
-				hweights = "data/fashion_hweights.model"
-				oweights = "data/fashion_oweights.model"
-			default:
-				hweights = "data/numbers_hweights.model"
-				oweights = "data/numbers_oweights.model"
+	if err := loadErr(net, dataset); err != nil {
+		panic(err)
 	}
-	h, err := os.Create(hweights)
-	o, err2 := os.Create(oweights)
-	defer h.Close()
-	defer o.Close()
-	if err == nil {
-		net.hiddenWeights.UnmarshalBinaryFrom(h)
+}
+
+// loadErr is load, failing with the file name and the error of a file that
+// cannot be decoded, or a *ShapeError of the file's shape and the param's if
+// a saved param does not fit the network, e.g. a model trained with other
+// -hidden sizes. The network is then left as it was.
+func loadErr(net *Network, dataset string) error {
+	files := modelFiles(dataset, len(net.layers))
+	layers := make([]Layer, len(net.layers))
+	for i, l := range net.layers {
+		ps := l.Params()
+		for j := range ps {
+			f, err := os.Open(files[i][j])
+			if err != nil {
+				continue
+			}
+			// the models are fixed point whatever the backend
+			m := new(Matrix)
+			err = m.UnmarshalBinaryFrom(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", files[i][j], err)
+			}
+			r, c := m.Dims()
+			pr, pc := ps[j].Dims()
+			if r != pr || c != pc {
+				return fmt.Errorf("%s: %w", files[i][j], &ShapeError{Op: "load", A: [2]int{r, c}, B: [2]int{pr, pc}})
+			}
+			ps[j] = net.backend.convert(m)
+		}
+		layers[i] = l.WithParams(ps)
 	}
-	if err2 == nil {
-		net.outputWeights.UnmarshalBinaryFrom(o)
+	net.layers = layers
+	if b, err := os.ReadFile(activationsFile(dataset)); err == nil {
+		for i, name := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if f, ok := ParseActivation(name); ok && i < len(net.layers) {
+				net.SetActivation(i, f)
+			}
+		}
 	}
-	return
+	return nil
 }
 
 // predict a number from an image
@@ -286,14 +496,7 @@ func predictFromImage(net Network, path string) int {
 	input := dataFromImage(path)
 	output := net.Predict(input)
 	//matrixPrint(output)
-	best := 0
-	highest := fixed(0)
-	for i := 0; i < net.outputs; i++ {
-		if output.At(i, 0) > highest {
-			best = i
-			highest = output.At(i, 0)
-		}
-	}
+	best, _ := ArgMax(AsMatrix(output))
 	return best
 }
 
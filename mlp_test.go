@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// inDataDir runs the test in a temporary directory with a data directory
+// for the models, returning to the working directory after.
+func inDataDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadShapeMismatch(t *testing.T) {
+	inDataDir(t)
+	save(NewNetwork(ONE, 6, 4, 3), "numbers")
+
+	net := NewNetwork(ONE, 6, 5, 3)
+	before := net.Layers()
+	err := loadErr(&net, "numbers")
+	if !errors.Is(err, ErrShape) {
+		t.Fatalf("loading 6-4-3 into 6-5-3 gave %v, want a shape error", err)
+	}
+	if !strings.Contains(err.Error(), "data/numbers_hweights.model") {
+		t.Errorf("error %q does not name the file", err)
+	}
+	for i, l := range net.Layers() {
+		if l != before[i] {
+			t.Errorf("layer %d replaced by a failed load", i)
+		}
+	}
+
+	ok := NewNetwork(ONE, 6, 4, 3)
+	if err := loadErr(&ok, "numbers"); err != nil {
+		t.Fatalf("loading 6-4-3 into 6-4-3: %v", err)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	inDataDir(t)
+	saved := NewNetwork(ONE, 6, 4, 3)
+	save(saved, "numbers")
+	b, err := os.ReadFile("data/numbers_oweights.model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, len(b) / 2} {
+		if err := os.WriteFile("data/numbers_oweights.model", b[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		net := NewNetwork(ONE, 6, 4, 3)
+		before := net.Layers()
+		err := loadErr(&net, "numbers")
+		if err == nil || !strings.Contains(err.Error(), "data/numbers_oweights.model") {
+			t.Fatalf("loading a model cut to %d of %d bytes gave %v, want an error naming the file", n, len(b), err)
+		}
+		if net.Layers()[0] != before[0] {
+			t.Errorf("cut to %d bytes: layer 0 replaced by a failed load", n)
+		}
+	}
+
+	// a missing bias file keeps the bias, as for models saved before biases
+	if err := os.WriteFile("data/numbers_oweights.model", b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("data/numbers_obias.model"); err != nil {
+		t.Fatal(err)
+	}
+	net := NewNetwork(ONE, 6, 4, 3)
+	bias := net.Layers()[1].Params()[1]
+	if err := loadErr(&net, "numbers"); err != nil {
+		t.Fatal(err)
+	}
+	if net.Layers()[1].Params()[1] != bias {
+		t.Error("a missing bias file replaced the bias")
+	}
+	w, want := net.Layers()[0].Params()[0], saved.Layers()[0].Params()[0]
+	if w.At(1, 2) != want.At(1, 2) {
+		t.Error("the saved weights were not loaded")
+	}
+}
+
+// TestLoadActivations checks that the activation of every layer is saved
+// and loaded with the model, and that a model without them keeps the
+// network's.
+func TestLoadActivations(t *testing.T) {
+	inDataDir(t)
+	saved := NewNetwork(ONE, 6, 5, 4, 3)
+	want := []Activation{ActivationELU, ActivationLeakyReLU, ActivationSoftmax}
+	for i, f := range want {
+		saved.SetActivation(i, f)
+	}
+	save(saved, "numbers")
+
+	net := NewNetwork(ONE, 6, 5, 4, 3)
+	if err := loadErr(&net, "numbers"); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range net.Layers() {
+		if got := l.(activated).Activation(); got != want[i] {
+			t.Errorf("layer %d loaded as %v, want %v", i, got, want[i])
+		}
+	}
+
+	if err := os.Remove(activationsFile("numbers")); err != nil {
+		t.Fatal(err)
+	}
+	net = NewNetwork(ONE, 6, 5, 4, 3)
+	net.SetActivation(1, ActivationTanh)
+	if err := loadErr(&net, "numbers"); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range net.Layers() {
+		f := ActivationSigmoid
+		if i == 1 {
+			f = ActivationTanh
+		}
+		if got := l.(activated).Activation(); got != f {
+			t.Errorf("without an activations file layer %d loaded as %v, want %v", i, got, f)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Arithmetic selects what happens to a result that does not fit the active
+// Format: Wrap drops the high bits like a plain register, Saturate clamps to
+// MAX or MIN.
+type Arithmetic int
+
+const (
+	Wrap Arithmetic = iota
+	Saturate
+)
+
+var arithmetic = Wrap
+
+func SetArithmetic(a Arithmetic) {
+	arithmetic = a
+}
+
+func (a Arithmetic) String() string {
+	if a == Saturate {
+		return "saturate"
+	}
+	return "wrap"
+}
+
+// op names the operation an overflow is charged to.
+type op int
+
+const (
+	opMul op = iota
+	opAdd
+	opSub
+	opProduct
+	opDiv
+	opExp
+	opLog
+	opSqrt
+	opConv
+	opReduce
+	numOps
+)
+
+var opNames = [numOps]string{"mul", "add", "sub", "product", "div", "exp", "log", "sqrt", "conv", "reduce"}
+
+// OpCount counts results that went above MAX (Overflow) or below MIN
+// (Underflow).
+type OpCount struct {
+	Overflow  uint64
+	Underflow uint64
+}
+
+// Counters holds an OpCount per operation, indexed like opNames.
+type Counters [numOps]OpCount
+
+var counters Counters
+
+// ReadCounters returns the overflows counted since the last ResetCounters,
+// e.g. after a call to Network.Train or Network.Predict.
+func ReadCounters() Counters {
+	var c Counters
+	for i := range counters {
+		c[i].Overflow = atomic.LoadUint64(&counters[i].Overflow)
+		c[i].Underflow = atomic.LoadUint64(&counters[i].Underflow)
+	}
+	return c
+}
+
+func ResetCounters() {
+	for i := range counters {
+		atomic.StoreUint64(&counters[i].Overflow, 0)
+		atomic.StoreUint64(&counters[i].Underflow, 0)
+	}
+}
+
+func (c Counters) Total() (n uint64) {
+	for _, oc := range c {
+		n += oc.Overflow + oc.Underflow
+	}
+	return
+}
+
+func (c Counters) String() string {
+	var b strings.Builder
+	for i, oc := range c {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%s:%d/%d", opNames[i], oc.Overflow, oc.Underflow)
+	}
+	return b.String()
+}
+
+// overflowed records that the result of o left the representable range,
+// upwards if up is set, and returns what the arithmetic mode makes of it.
+// x is the result already wrapped to 64 bits.
+func overflowed(o op, up bool, x fixed) fixed {
+	if up {
+		atomic.AddUint64(&counters[o].Overflow, 1)
+	} else {
+		atomic.AddUint64(&counters[o].Underflow, 1)
+	}
+	if arithmetic == Saturate {
+		if up {
+			return MAX
+		}
+		return MIN
+	}
+	return wrap(x)
+}
+
+// fit brings an exact int64 result of o into the active format.
+func fit(x fixed, o op) fixed {
+	switch {
+	case x > MAX:
+		return overflowed(o, true, x)
+	case x < MIN:
+		return overflowed(o, false, x)
+	}
+	return x
+}
+
+func addFixed(a, b fixed, o op) fixed {
+	s := a + b
+	switch {
+	case b > 0 && s < a:
+		return overflowed(o, true, s)
+	case b < 0 && s > a:
+		return overflowed(o, false, s)
+	}
+	return fit(s, o)
+}
+
+func subFixed(a, b fixed, o op) fixed {
+	s := a - b
+	switch {
+	case b < 0 && s < a:
+		return overflowed(o, true, s)
+	case b > 0 && s > a:
+		return overflowed(o, false, s)
+	}
+	return fit(s, o)
+}
+
+func magnitude(x fixed) uint64 {
+	if x < 0 {
+		return uint64(-x)
+	}
+	return uint64(x)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// TestAddSubOverflow checks Add and Sub against the exact sums under each
+// arithmetic mode, and that each result out of range is counted once.
+func TestAddSubOverflow(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			withFormat(t, f, a, RoundHalfEven, func() {
+				var overflows int
+				check := func(x, y fixed) {
+					for _, c := range []struct {
+						name string
+						o    op
+						fn   func(m *Matrix, a, b Mat)
+						ref  func(z, x, y *big.Int) *big.Int
+					}{
+						{"+", opAdd, (*Matrix).Add, (*big.Int).Add},
+						{"-", opSub, (*Matrix).Sub, (*big.Int).Sub},
+					} {
+						m := NewMatrix(1, 1, nil)
+						ResetCounters()
+						c.fn(m, NewMatrix(1, 1, []fixed{x}), NewMatrix(1, 1, []fixed{y}))
+						want, up, down := refFit(c.ref(new(big.Int), big.NewInt(int64(x)), big.NewInt(int64(y))))
+						if got := m.At(0, 0); got != want {
+							t.Fatalf("%v %v: %d %s %d = %d, want %d", f, a, x, c.name, y, got, want)
+						}
+						counts := ReadCounters()
+						if n := counts[c.o]; (n.Overflow == 1) != up || (n.Underflow == 1) != down || counts.Total() != n.Overflow+n.Underflow {
+							t.Fatalf("%v %v: %d %s %d counted %v, want up %v down %v", f, a, x, c.name, y, counts, up, down)
+						}
+						if up || down {
+							overflows++
+						}
+					}
+				}
+				for _, x := range edges() {
+					for _, y := range edges() {
+						check(x, y)
+					}
+				}
+				for i := 0; i < 20000; i++ {
+					check(randomFixed(rng), randomFixed(rng))
+				}
+				// values within one of MAX overflow the sum or, with one
+				// negated, the difference
+				near := func() fixed { return MAX - fixed(rng.Int63n(int64(ONE))) }
+				for i := 0; i < 1000; i++ {
+					check(near(), near())
+					check(-near(), near())
+				}
+				if overflows < 1000 {
+					t.Fatalf("%v %v: only %d overflows", f, a, overflows)
+				}
+			})
+		}
+	}
+}
+
+// TestOverflowModes checks a division, an exponential and conversions that
+// leave the format: saturated to MAX or MIN, or wrapped to the low bits of
+// the exact result, and counted once against their operation.
+func TestOverflowModes(t *testing.T) {
+	defer ResetCounters()
+	for _, f := range testFormats {
+		large := float64(f.IntBits)*math.Ln2 + 1
+		cases := []struct {
+			name string
+			o    op
+			up   bool
+			fn   func() (fixed, error)
+			err  error
+			// wrapped is the exact result in ulps, before it is wrapped; tol
+			// is how far exp, an approximation, may be from it
+			wrapped func() *big.Int
+			tol     float64
+		}{
+			{"MAX / 0.5", opDiv, true, func() (fixed, error) { return DivideFixedErr(MAX, ONE_HALF) }, ErrOverflow,
+				func() *big.Int { return new(big.Int).Lsh(big.NewInt(int64(MAX)), 1) }, 0},
+			{"MIN / 0.5", opDiv, false, func() (fixed, error) { return DivideFixedErr(MIN, ONE_HALF) }, ErrOverflow,
+				func() *big.Int { return new(big.Int).Lsh(big.NewInt(int64(MIN)), 1) }, 0},
+			{"exp", opExp, true, func() (fixed, error) { return exp(floatToFixed(large)), nil }, nil,
+				func() *big.Int {
+					v, _ := new(big.Float).SetMantExp(big.NewFloat(math.Exp(toFloat(floatToFixed(large)))), int(fracBits)).Int(nil)
+					return v
+				}, math.Ldexp(1, f.IntBits+4) + math.Ldexp(math.E, f.Bits())*1e-9},
+			{"int MAX+1", opConv, true, func() (fixed, error) { return IntToFixedErr(1 << uint(f.IntBits-1)) }, ErrOverflow,
+				func() *big.Int { return new(big.Int).Lsh(big.NewInt(1), uint(f.Bits()-1)) }, 0},
+			{"int MIN-1", opConv, false, func() (fixed, error) { return IntToFixedErr(-1<<uint(f.IntBits-1) - 1) }, ErrOverflow,
+				func() *big.Int {
+					v := new(big.Int).Lsh(big.NewInt(-1), uint(f.Bits()-1))
+					return v.Sub(v, big.NewInt(int64(ONE)))
+				}, 0},
+			{"float 1e300", opConv, true, func() (fixed, error) { return FloatToFixedErr(1e300) }, ErrOverflow,
+				func() *big.Int {
+					v, _ := new(big.Float).SetMantExp(big.NewFloat(1e300), int(fracBits)).Int(nil)
+					return v
+				}, 0},
+			{"float -2^IntBits", opConv, false, func() (fixed, error) { return FloatToFixedErr(-math.Ldexp(1, f.IntBits)) }, ErrOverflow,
+				func() *big.Int { return new(big.Int).Lsh(big.NewInt(-1), uint(f.Bits())) }, 0},
+		}
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			withFormat(t, f, a, RoundHalfEven, func() {
+				for _, c := range cases {
+					ResetCounters()
+					got, err := c.fn()
+					if !errors.Is(err, c.err) {
+						t.Errorf("%v %v: %s gave error %v, want %v", f, a, c.name, err, c.err)
+					}
+					counts := ReadCounters()
+					if n := counts[c.o]; counts.Total() != 1 || c.up && n.Overflow != 1 || !c.up && n.Underflow != 1 {
+						t.Errorf("%v %v: %s counted %v", f, a, c.name, counts)
+					}
+					if a == Saturate {
+						if want := map[bool]fixed{true: MAX, false: MIN}[c.up]; got != want {
+							t.Errorf("%v %v: %s = %d, want %d", f, a, c.name, got, want)
+						}
+						continue
+					}
+					want, _, _ := refFit(c.wrapped())
+					if d := wrap(got - want); math.Abs(float64(d)) > c.tol {
+						t.Errorf("%v %v: %s = %d, want %d", f, a, c.name, got, want)
+					}
+				}
+			})
+		}
+	}
+}
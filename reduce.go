@@ -0,0 +1,391 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// Axis picks the direction a reduction runs in.
+type Axis int
+
+const (
+	// AlongRows reduces every row to one value, giving an r x 1 column
+	AlongRows Axis = iota
+	// AlongCols reduces every column to one value, giving a 1 x c row
+	AlongCols
+)
+
+// The reductions sum exactly in 128 bits and round once, so only a result
+// that does not fit the format overflows; that is counted against reduce.
+// Mean, Variance, ArgMax and ArgMin fail on an empty matrix; the plain
+// versions panic with the *IndexError the Err versions return.
+
+// Sum adds up every element of m.
+func Sum(m *Matrix) fixed {
+	var s int128
+	for r := 0; r < m.row; r++ {
+		for c := 0; c < m.col; c++ {
+			x := m.at(r, c)
+			s = s.add(int128{uint64(int64(x) >> 63), uint64(x)})
+		}
+	}
+	return s.fixed(opReduce)
+}
+
+func Mean(m *Matrix) fixed {
+	v, err := MeanErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// MeanErr is the exact sum of m divided by its size, rounded once.
+func MeanErr(m *Matrix) (fixed, error) {
+	if err := m.nonEmpty("Mean"); err != nil {
+		return 0, err
+	}
+	var s int128
+	for r := 0; r < m.row; r++ {
+		for c := 0; c < m.col; c++ {
+			x := m.at(r, c)
+			s = s.add(int128{uint64(int64(x) >> 63), uint64(x)})
+		}
+	}
+	isNegative := s.negative()
+	if isNegative {
+		s = s.neg()
+	}
+	// |s| < n * 2^63, so the high word is below n
+	n := uint64(m.row * m.col)
+	q, rem := bits.Div64(s.hi, s.lo, n)
+	if roundsUp(q, rem, n, isNegative, rounding) {
+		q++
+	}
+	if isNegative {
+		return -fixed(q), nil
+	}
+	return fixed(q), nil
+}
+
+func Variance(m *Matrix) fixed {
+	v, err := VarianceErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// VarianceErr is the population variance of m, the mean squared deviation
+// from Mean(m), with the squares summed exactly and rounded once.
+func VarianceErr(m *Matrix) (fixed, error) {
+	mean, err := MeanErr(m)
+	if err != nil {
+		return 0, err
+	}
+	// the deviations can take 64 bits unsigned, their squares 128; ext
+	// counts the carries out of the sum
+	var s int128
+	var ext uint64
+	for r := 0; r < m.row; r++ {
+		for c := 0; c < m.col; c++ {
+			x := m.at(r, c)
+			d := uint64(x - mean)
+			if x < mean {
+				d = uint64(mean - x)
+			}
+			hi, lo := bits.Mul64(d, d)
+			var carry uint64
+			s.lo, carry = bits.Add64(s.lo, lo, 0)
+			s.hi, carry = bits.Add64(s.hi, hi, carry)
+			ext += carry
+		}
+	}
+	if ext != 0 {
+		return overflowed(opReduce, true, MAX), nil
+	}
+	// divide by n, then by 2^fracBits; a non-zero remainder is kept as a
+	// sticky low bit, so the one rounding of shr sees it
+	n := uint64(m.row * m.col)
+	qhi, rem := s.hi/n, s.hi%n
+	qlo, rem := bits.Div64(rem, s.lo, n)
+	if rem != 0 {
+		qlo |= 1
+	}
+	if qhi >= 1<<62 {
+		return overflowed(opReduce, true, MAX), nil
+	}
+	return int128{qhi, qlo}.shr(fracBits, rounding).fixed(opReduce), nil
+}
+
+// Norm is the Euclidean (Frobenius) norm of m, the correctly rounded square
+// root of the exact sum of squares.
+func Norm(m *Matrix) fixed {
+	var s wideSum
+	for r := 0; r < m.row; r++ {
+		for c := 0; c < m.col; c++ {
+			x := m.at(r, c)
+			s.add(mul128(x, x))
+		}
+	}
+	// sqrt128 needs its argument below 2^126, and anything above overflows
+	if s.ext != 0 || s.x.hi >= 1<<62 {
+		return overflowed(opReduce, true, MAX)
+	}
+	return fit(fixed(sqrt128(s.x.hi, s.x.lo, rounding)), opReduce)
+}
+
+func ArgMax(m *Matrix) (r, c int) {
+	r, c, err := ArgMaxErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return r, c
+}
+
+// ArgMaxErr returns the position of the largest element of m, the first in
+// row-major order if there are several.
+func ArgMaxErr(m *Matrix) (r, c int, err error) {
+	return m.arg("ArgMax", func(x, best fixed) bool { return x > best })
+}
+
+func ArgMin(m *Matrix) (r, c int) {
+	r, c, err := ArgMinErr(m)
+	if err != nil {
+		panic(err)
+	}
+	return r, c
+}
+
+// ArgMinErr returns the position of the smallest element of m, the first in
+// row-major order if there are several.
+func ArgMinErr(m *Matrix) (r, c int, err error) {
+	return m.arg("ArgMin", func(x, best fixed) bool { return x < best })
+}
+
+func (m *Matrix) arg(op string, better func(x, best fixed) bool) (br, bc int, err error) {
+	if err := m.nonEmpty(op); err != nil {
+		return 0, 0, err
+	}
+	best := m.at(0, 0)
+	for r := 0; r < m.row; r++ {
+		for c := 0; c < m.col; c++ {
+			if x := m.at(r, c); better(x, best) {
+				best, br, bc = x, r, c
+			}
+		}
+	}
+	return br, bc, nil
+}
+
+func (m *Matrix) nonEmpty(op string) error {
+	return m.inRange(op, 0, 0)
+}
+
+// SumAlong sums every row or every column of m.
+func SumAlong(m *Matrix, axis Axis) *Matrix {
+	o, _ := along(m, axis, func(v *Matrix) (fixed, error) { return Sum(v), nil })
+	return o
+}
+
+// SumAlongErr sets m to SumAlong(a, axis), which it must be the shape of.
+func (m *Matrix) SumAlongErr(x Mat, axis Axis) error {
+	a := AsMatrix(x)
+	if axis == AlongCols {
+		if err := m.holds("SumAlong", 1, a.col); err != nil {
+			return err
+		}
+		for c := 0; c < a.col; c++ {
+			m.set(0, c, Sum(a.ColView(c)))
+		}
+		return nil
+	}
+	if err := m.holds("SumAlong", a.row, 1); err != nil {
+		return err
+	}
+	for r := 0; r < a.row; r++ {
+		m.set(r, 0, Sum(a.RowView(r)))
+	}
+	return nil
+}
+
+func MeanAlong(m *Matrix, axis Axis) *Matrix {
+	o, err := MeanAlongErr(m, axis)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func MeanAlongErr(m *Matrix, axis Axis) (*Matrix, error) {
+	return along(m, axis, MeanErr)
+}
+
+func VarianceAlong(m *Matrix, axis Axis) *Matrix {
+	o, err := VarianceAlongErr(m, axis)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func VarianceAlongErr(m *Matrix, axis Axis) (*Matrix, error) {
+	return along(m, axis, VarianceErr)
+}
+
+// NormAlong is the Euclidean norm of every row or every column of m.
+func NormAlong(m *Matrix, axis Axis) *Matrix {
+	o, _ := along(m, axis, func(v *Matrix) (fixed, error) { return Norm(v), nil })
+	return o
+}
+
+func ArgMaxAlong(m *Matrix, axis Axis) []int {
+	i, err := ArgMaxAlongErr(m, axis)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// ArgMaxAlongErr returns the column of the largest element of every row,
+// or the row of the largest element of every column.
+func ArgMaxAlongErr(m *Matrix, axis Axis) ([]int, error) {
+	return argAlong(m, axis, ArgMaxErr)
+}
+
+func ArgMinAlong(m *Matrix, axis Axis) []int {
+	i, err := ArgMinAlongErr(m, axis)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// ArgMinAlongErr is ArgMaxAlongErr for the smallest elements.
+func ArgMinAlongErr(m *Matrix, axis Axis) ([]int, error) {
+	return argAlong(m, axis, ArgMinErr)
+}
+
+// along applies f to every row or column view of m.
+func along(m *Matrix, axis Axis, f func(*Matrix) (fixed, error)) (*Matrix, error) {
+	if axis == AlongCols {
+		o := NewMatrix(1, m.col, nil)
+		for c := 0; c < m.col; c++ {
+			v, err := f(m.ColView(c))
+			if err != nil {
+				return nil, err
+			}
+			o.set(0, c, v)
+		}
+		return o, nil
+	}
+	o := NewMatrix(m.row, 1, nil)
+	for r := 0; r < m.row; r++ {
+		v, err := f(m.RowView(r))
+		if err != nil {
+			return nil, err
+		}
+		o.set(r, 0, v)
+	}
+	return o, nil
+}
+
+func argAlong(m *Matrix, axis Axis, f func(*Matrix) (int, int, error)) ([]int, error) {
+	if axis == AlongCols {
+		idx := make([]int, m.col)
+		for c := range idx {
+			r, _, err := f(m.ColView(c))
+			if err != nil {
+				return nil, err
+			}
+			idx[c] = r
+		}
+		return idx, nil
+	}
+	idx := make([]int, m.row)
+	for r := range idx {
+		_, c, err := f(m.RowView(r))
+		if err != nil {
+			return nil, err
+		}
+		idx[r] = c
+	}
+	return idx, nil
+}
+
+func (m *Matrix) Broadcast(fn func(x, y fixed) fixed, a, b *Matrix) {
+	if err := m.BroadcastErr(fn, a, b); err != nil {
+		panic(err)
+	}
+}
+
+// BroadcastErr sets every element of m to fn of the corresponding elements
+// of a and b, where an operand with a single row or column, or a 1 x 1
+// scalar, is repeated along it to the shape of the other.
+func (m *Matrix) BroadcastErr(fn func(x, y fixed) fixed, a, b *Matrix) error {
+	return m.broadcast("Broadcast", fn, a, b)
+}
+
+func (m *Matrix) AddBroadcast(a, b Mat) {
+	if err := m.AddBroadcastErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) AddBroadcastErr(a, b Mat) error {
+	return m.broadcast("AddBroadcast", func(x, y fixed) fixed { return addFixed(x, y, opAdd) }, AsMatrix(a), AsMatrix(b))
+}
+
+func (m *Matrix) SubBroadcast(a, b Mat) {
+	if err := m.SubBroadcastErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) SubBroadcastErr(a, b Mat) error {
+	return m.broadcast("SubBroadcast", func(x, y fixed) fixed { return subFixed(x, y, opSub) }, AsMatrix(a), AsMatrix(b))
+}
+
+func (m *Matrix) MulElemBroadcast(a, b Mat) {
+	if err := m.MulElemBroadcastErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Matrix) MulElemBroadcastErr(a, b Mat) error {
+	return m.broadcast("MulElemBroadcast", MultiplyFixed, AsMatrix(a), AsMatrix(b))
+}
+
+// Scalar returns v as a 1 x 1 matrix to broadcast.
+func Scalar(v fixed) *Matrix {
+	return NewMatrix(1, 1, []fixed{v})
+}
+
+func (m *Matrix) broadcast(op string, fn func(x, y fixed) fixed, a, b *Matrix) error {
+	r, ok := broadcastDim(a.row, b.row)
+	c, ok2 := broadcastDim(a.col, b.col)
+	if !ok || !ok2 {
+		return &ShapeError{Op: op, A: [2]int{a.row, a.col}, B: [2]int{b.row, b.col}}
+	}
+	if err := m.holds(op, r, c); err != nil {
+		return err
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.set(i, j, fn(a.at(i%a.row, j%a.col), b.at(i%b.row, j%b.col)))
+		}
+	}
+	return nil
+}
+
+// broadcastDim is the length two operands of length x and y broadcast to.
+func broadcastDim(x, y int) (int, bool) {
+	switch {
+	case x == y:
+		return x, true
+	case x == 1:
+		return y, true
+	case y == 1:
+		return x, true
+	}
+	return 0, false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// refDivide is num / den rounded to an integer with r, for den > 0.
+func refDivide(num, den *big.Int, r Rounding) *big.Int {
+	q, rem := new(big.Int).DivMod(num, den, new(big.Int))
+	c := new(big.Int).Lsh(rem, 1).Cmp(den)
+	switch {
+	case r == RoundHalfUp && c >= 0,
+		r == RoundHalfEven && (c > 0 || c == 0 && q.Bit(0) == 1):
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+// refReductions are Sum, Mean, Variance and Norm of m from exact sums.
+func refReductions(m *Matrix, r Rounding) (sum, mean, variance, norm fixed) {
+	rows, cols := m.Dims()
+	n := big.NewInt(int64(rows * cols))
+	s, sq := new(big.Int), new(big.Int)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			x := big.NewInt(int64(m.At(i, j)))
+			s.Add(s, x)
+			sq.Add(sq, new(big.Int).Mul(x, x))
+		}
+	}
+	sum, _, _ = refFit(s)
+	mean, _, _ = refFit(refDivide(s, n, r))
+	dev := new(big.Int)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			d := big.NewInt(int64(m.At(i, j)) - int64(mean))
+			dev.Add(dev, d.Mul(d, d))
+		}
+	}
+	variance, _, _ = refFit(refDivide(dev, new(big.Int).Lsh(n, fracBits), r))
+	norm, _, _ = refFit(refSqrt(sq, r))
+	return
+}
+
+func TestReductions(t *testing.T) {
+	rng := rand.New(rand.NewSource(15))
+	for _, f := range []Format{Q16_48, Q16_16, Q8_8} {
+		for _, r := range testRoundings {
+			withFormat(t, f, Saturate, r, func() {
+				for i := 0; i < 200; i++ {
+					rows, cols := 1+rng.Intn(6), 1+rng.Intn(6)
+					tr := rng.Intn(2) == 0
+					m := transposed(gemmOperand(rng, rows, cols, tr, rng.Intn(2) == 0), tr)
+					// small values too, so the sums stay in range
+					if rng.Intn(2) == 0 {
+						m.Scale(fixed(1) << uint(fracBits/2))
+					}
+					sum, mean, variance, norm := refReductions(m, r)
+					if got := Sum(m); got != sum {
+						t.Fatalf("%v %v: Sum = %d, want %d", f, r, got, sum)
+					}
+					if got := Mean(m); got != mean {
+						t.Fatalf("%v %v: Mean = %d, want %d", f, r, got, mean)
+					}
+					if got := Variance(m); got != variance {
+						t.Fatalf("%v %v: Variance = %d, want %d", f, r, got, variance)
+					}
+					if got := Norm(m); got != norm {
+						t.Fatalf("%v %v: Norm = %d, want %d", f, r, got, norm)
+					}
+
+					// the reductions along an axis are those of the rows or
+					// columns
+					for _, axis := range []Axis{AlongRows, AlongCols} {
+						n, part := rows, func(k int) *Matrix { return Copy(m.RowView(k)) }
+						if axis == AlongCols {
+							n, part = cols, func(k int) *Matrix { return Copy(m.ColView(k)) }
+						}
+						sums, means, vars, norms := SumAlong(m, axis), MeanAlong(m, axis), VarianceAlong(m, axis), NormAlong(m, axis)
+						maxes, mins := ArgMaxAlong(m, axis), ArgMinAlong(m, axis)
+						for k := 0; k < n; k++ {
+							i, j := k, 0
+							if axis == AlongCols {
+								i, j = 0, k
+							}
+							p := part(k)
+							sum, mean, variance, norm := refReductions(p, r)
+							if sums.At(i, j) != sum || means.At(i, j) != mean || vars.At(i, j) != variance || norms.At(i, j) != norm {
+								t.Fatalf("%v %v: along %d, %d differs from the reductions of it", f, r, axis, k)
+							}
+							pr, pc := ArgMax(p)
+							if maxes[k] != pr+pc {
+								t.Fatalf("%v %v: ArgMaxAlong %d, %d = %d, want %d", f, r, axis, k, maxes[k], pr+pc)
+							}
+							pr, pc = ArgMin(p)
+							if mins[k] != pr+pc {
+								t.Fatalf("%v %v: ArgMinAlong %d, %d = %d, want %d", f, r, axis, k, mins[k], pr+pc)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestArgMax(t *testing.T) {
+	m := NewMatrix(3, 4, []fixed{
+		1, 5, 0, 5,
+		-2, 5, -2, 3,
+		0, -2, 4, 1,
+	})
+	if r, c := ArgMax(m); r != 0 || c != 1 {
+		t.Errorf("ArgMax = (%d, %d), want the first 5 at (0, 1)", r, c)
+	}
+	if r, c := ArgMin(m); r != 1 || c != 0 {
+		t.Errorf("ArgMin = (%d, %d), want the first -2 at (1, 0)", r, c)
+	}
+	if r, c := ArgMax(m.T()); r != 1 || c != 0 {
+		t.Errorf("ArgMax of the transpose = (%d, %d), want (1, 0)", r, c)
+	}
+	for _, c := range []struct {
+		name string
+		got  []int
+		want []int
+	}{
+		{"ArgMaxAlong rows", ArgMaxAlong(m, AlongRows), []int{1, 1, 2}},
+		{"ArgMaxAlong cols", ArgMaxAlong(m, AlongCols), []int{0, 0, 2, 0}},
+		{"ArgMinAlong rows", ArgMinAlong(m, AlongRows), []int{2, 0, 1}},
+		{"ArgMinAlong cols", ArgMinAlong(m, AlongCols), []int{1, 2, 1, 2}},
+	} {
+		for i := range c.want {
+			if c.got[i] != c.want[i] {
+				t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+				break
+			}
+		}
+	}
+
+	empty := NewMatrix(0, 3, nil)
+	if Sum(empty) != 0 || SumAlong(empty, AlongCols).At(0, 2) != 0 {
+		t.Error("the sum of nothing is not 0")
+	}
+	for name, fn := range map[string]func() error{
+		"MeanErr":         func() error { _, err := MeanErr(empty); return err },
+		"VarianceErr":     func() error { _, err := VarianceErr(empty); return err },
+		"ArgMaxErr":       func() error { _, _, err := ArgMaxErr(empty); return err },
+		"ArgMinErr":       func() error { _, _, err := ArgMinErr(empty); return err },
+		"MeanAlongErr":    func() error { _, err := MeanAlongErr(empty, AlongCols); return err },
+		"ArgMaxAlongErr":  func() error { _, err := ArgMaxAlongErr(empty, AlongCols); return err },
+		"VarianceAlong 0": func() error { _, err := VarianceAlongErr(NewMatrix(2, 0, nil), AlongRows); return err },
+	} {
+		var ie *IndexError
+		if err := fn(); !errors.As(err, &ie) {
+			t.Errorf("%s of an empty matrix gave %v, want an *IndexError", name, err)
+		}
+	}
+}
+
+// TestSumOverflow checks that the reductions only overflow when the
+// result does, and count it once.
+func TestSumOverflow(t *testing.T) {
+	defer ResetCounters()
+	for _, f := range []Format{Q16_48, Q8_8} {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			for _, c := range []struct {
+				name string
+				got  func() fixed
+				want fixed
+				n    uint64
+			}{
+				{"Sum back in range", func() fixed { return Sum(NewMatrix(1, 3, []fixed{MAX, MAX, -MAX})) }, MAX, 0},
+				{"Sum", func() fixed { return Sum(NewMatrix(1, 3, []fixed{MAX, MAX, 1})) }, MAX, 1},
+				{"Sum down", func() fixed { return Sum(NewMatrix(1, 2, []fixed{MIN, -1})) }, MIN, 1},
+				{"Mean", func() fixed { return Mean(NewMatrix(1, 3, []fixed{MAX, MAX, MAX})) }, MAX, 0},
+				{"Variance", func() fixed { return Variance(NewMatrix(1, 2, []fixed{MIN, MAX})) }, MAX, 1},
+				{"Norm", func() fixed { return Norm(NewMatrix(1, 2, []fixed{MAX, MAX})) }, MAX, 1},
+			} {
+				ResetCounters()
+				got := c.got()
+				if n := ReadCounters()[opReduce]; got != c.want || n.Overflow+n.Underflow != c.n {
+					t.Errorf("%v: %s = %d, counted %+v, want %d and %d", f, c.name, got, n, c.want, c.n)
+				}
+			}
+		})
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	col := NewMatrix(3, 1, []fixed{ONE, 2 * ONE, 3 * ONE})
+	row := NewMatrix(1, 2, []fixed{10 * ONE, 20 * ONE})
+	m := NewMatrix(3, 2, nil)
+	m.AddBroadcast(col, row)
+	m2 := NewMatrix(3, 2, nil)
+	m2.SubBroadcast(m, Scalar(ONE))
+	m3 := NewMatrix(3, 2, nil)
+	m3.MulElemBroadcast(m2, row.T().T())
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 2; j++ {
+			sum := fixed(i+1)*ONE + fixed(10*(j+1))*ONE
+			if m.At(i, j) != sum || m2.At(i, j) != sum-ONE || m3.At(i, j) != (sum-ONE)*fixed(10*(j+1)) {
+				t.Errorf("(%d, %d): %v, %v, %v", i, j, toFloat(m.At(i, j)), toFloat(m2.At(i, j)), toFloat(m3.At(i, j)))
+			}
+		}
+	}
+
+	// a row broadcast down a transposed view
+	v := NewMatrix(2, 3, nil)
+	v.Broadcast(func(x, y fixed) fixed { return x - y }, col.T(), NewMatrix(2, 1, []fixed{0, ONE}))
+	if v.At(1, 2) != 2*ONE || v.At(0, 0) != ONE {
+		t.Errorf("Broadcast of a transposed column = %v", v.data)
+	}
+
+	var se *ShapeError
+	if err := m.AddBroadcastErr(NewMatrix(2, 3, nil), NewMatrix(3, 2, nil)); !errors.As(err, &se) {
+		t.Errorf("2x3 + 3x2 gave %v, want a *ShapeError", err)
+	}
+	if err := NewMatrix(2, 2, nil).AddBroadcastErr(col, row); !errors.As(err, &se) || !se.Receiver {
+		t.Errorf("a 3x2 result into 2x2 gave %v, want a *ShapeError for the receiver", err)
+	}
+}
+
+// TestSumAlongErr checks the Mat method on both backends against SumAlong.
+func TestSumAlongErr(t *testing.T) {
+	rng := rand.New(rand.NewSource(16))
+	m := gemmOperand(rng, 5, 3, false, true)
+	m.Scale(ONE >> 4)
+	for _, axis := range []Axis{AlongRows, AlongCols} {
+		want := SumAlong(m, axis)
+		r, c := want.Dims()
+		for _, b := range []Backend{FixedPoint, Float64} {
+			got := b.convert(m).New(r, c)
+			if err := got.SumAlongErr(b.convert(m), axis); err != nil {
+				t.Fatalf("%v %d: %v", b, axis, err)
+			}
+			for i := 0; i < r; i++ {
+				for j := 0; j < c; j++ {
+					// the float64 sums are rounded to 53 bits
+					if d := toFloat(got.At(i, j) - want.At(i, j)); math.Abs(d) > toFloat(1)+math.Abs(want.AtFloat(i, j))*0x1p-50 {
+						t.Errorf("%v %d: (%d, %d) = %d, want %d", b, axis, i, j, got.At(i, j), want.At(i, j))
+					}
+				}
+			}
+			var se *ShapeError
+			if err := b.convert(m).New(c, r).SumAlongErr(m, axis); !errors.As(err, &se) || !se.Receiver {
+				t.Errorf("%v %d: a %dx%d receiver gave %v", b, axis, c, r, err)
+			}
+		}
+	}
+}
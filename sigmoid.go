@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Squash selects how a Network computes the logistic function. The
+// approximations avoid the exp and DivideFixed of the exact sigmoid, the way
+// embedded targets do it. Training keeps using m * (1 - m) as the
+// derivative whichever is selected.
+type Squash int
+
+const (
+	// SquashExact is sigmoid itself
+	SquashExact Squash = iota
+	// SquashTable interpolates linearly between sigmoid values tabulated
+	// every 1/16 on [0, 8], using sigmoid(-x) = 1 - sigmoid(x); it is within
+	// 5e-5 of sigmoid on [-8, 8], plus a few ulps in formats coarser than
+	// that, and holds sigmoid(8), within 3.4e-4, beyond
+	SquashTable
+	// SquashPLAN is the piecewise linear approximation of Amin, Curtis and
+	// Hayes-Gill, whose slopes are all powers of two; it is within 0.019
+	SquashPLAN
+	// SquashHard is x/4 + 1/2 clamped to [0, 1], within 0.12
+	SquashHard
+	numSquashes
+)
+
+var squashNames = [numSquashes]string{"exact", "table", "plan", "hard"}
+
+func (s Squash) String() string {
+	return squashNames[s]
+}
+
+// ParseSquash is the inverse of Squash.String.
+func ParseSquash(name string) (Squash, bool) {
+	for s, n := range squashNames {
+		if n == name {
+			return Squash(s), true
+		}
+	}
+	return SquashExact, false
+}
+
+func (s Squash) fn() func(r, c int, z fixed) fixed {
+	switch s {
+	case SquashTable:
+		return sigmoidTable
+	case SquashPLAN:
+		return sigmoidPLAN
+	case SquashHard:
+		return sigmoidHard
+	}
+	return sigmoid
+}
+
+const (
+	sigmoidTableBits  = 4 // entries per unit, as a power of two
+	sigmoidTableRange = 8
+)
+
+// sigmoidLUT is rebuilt by SetFormat. Like a ROM generated offline it is
+// filled in with package math; looking values up is integer only.
+var sigmoidLUT [sigmoidTableRange<<sigmoidTableBits + 1]fixed
+
+func buildSigmoidTable() {
+	for i := range sigmoidLUT {
+		x := math.Ldexp(float64(i), -sigmoidTableBits)
+		sigmoidLUT[i] = fixed(math.Round(math.Ldexp(1/(1+math.Exp(-x)), int(fracBits))))
+	}
+}
+
+func sigmoidTable(r, c int, z fixed) fixed {
+	x := z
+	if(x < 0){
+		x = -x
+		if(x < 0){
+			x = MAX
+		}
+	}
+	step := fracBits - sigmoidTableBits
+	i := int64(x >> step)
+	var y fixed
+	if(i >= int64(len(sigmoidLUT)) - 1){
+		y = sigmoidLUT[len(sigmoidLUT) - 1]
+	} else {
+		y = sigmoidLUT[i]
+		if frac := x & (fixed(1)<<step - 1); frac != 0 {
+			y += mul128(sigmoidLUT[i+1] - y, frac).shr(step, Truncate).fixed(opMul)
+		}
+	}
+	if(z < 0){
+		return ONE - y
+	}
+	return y
+}
+
+func sigmoidPLAN(r, c int, z fixed) fixed {
+	x := z
+	if(x < 0){
+		x = -x
+		if(x < 0){
+			x = MAX
+		}
+	}
+	// compare in eighths so the breakpoints need not be representable
+	eighths := x >> (fracBits - 3)
+	var y fixed
+	switch {
+	case eighths >= 40:
+		y = ONE
+	case eighths >= 19: // 2.375
+		y = x >> 5 + (ONE >> 5) * 27 // 0.03125x + 0.84375
+	case eighths >= 8:
+		y = x >> 3 + (ONE >> 3) * 5 // 0.125x + 0.625
+	default:
+		y = x >> 2 + ONE_HALF // 0.25x + 0.5
+	}
+	if(z < 0){
+		return ONE - y
+	}
+	return y
+}
+
+func sigmoidHard(r, c int, z fixed) fixed {
+	return fixedMax(0, fixedMin(ONE, z >> 2 + ONE_HALF))
+}
+
+// SquashError compares s with the exact sigmoid at every 1/1024 on [-8, 8]
+// (or the format's range, if smaller) and returns the largest and the mean
+// absolute difference.
+func SquashError(s Squash) (max, mean fixed) {
+	fn := s.fn()
+	limit := MAX
+	if(format.IntBits > 4){
+		limit = sigmoidTableRange * ONE
+	}
+	step := ONE >> 10
+	if(step == 0){
+		step = 1
+	}
+	var hi, sum, carry, n uint64
+	for x := -limit; x <= limit - step; x += step {
+		d := fn(0, 0, x) - sigmoid(0, 0, x)
+		if(d < 0){
+			d = -d
+		}
+		max = fixedMax(max, d)
+		sum, carry = bits.Add64(sum, uint64(d), 0)
+		hi += carry
+		n++
+	}
+	q, _ := bits.Div64(hi, sum, n)
+	return max, fixed(q)
+}
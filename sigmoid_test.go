@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// squashBounds are the distances from sigmoid in the docs of the Squash
+// constants, in values and in ulps of the active format.
+var squashBounds = [numSquashes]struct{ value, ulps float64 }{
+	SquashExact: {0, 2},
+	SquashTable: {5e-5, 2},
+	SquashPLAN:  {0.019, 2},
+	SquashHard:  {0.12, 2},
+}
+
+// TestSquashAccuracy checks each Squash against the float64 logistic
+// function, and that each is in [0, 1], monotonic but for PLAN, and, the
+// table and PLAN, symmetric about (0, 1/2).
+func TestSquashAccuracy(t *testing.T) {
+	for _, f := range []Format{Q16_48, Q8_24, Q16_16, Q4_12, Q8_8} {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			limit := math.Min(10, toFloat(MAX))
+			for s := Squash(0); s < numSquashes; s++ {
+				fn := s.fn()
+				bound := squashBounds[s].value + squashBounds[s].ulps*toFloat(1)
+				prev := fixed(0)
+				for x := -limit; x <= limit; x += 1.0 / 512 {
+					z := floatToFixed(x)
+					got := fn(0, 0, z)
+					want := 1 / (1 + math.Exp(-toFloat(z)))
+					b := bound
+					if s == SquashTable && math.Abs(x) > sigmoidTableRange {
+						b = 3.4e-4
+					}
+					if e := math.Abs(toFloat(got) - want); e > b {
+						t.Fatalf("%v %v: sigmoid(%v) = %v, want %v: error %g", f, s, toFloat(z), toFloat(got), want, e)
+					}
+					// PLAN's segments meet 1/256 apart at 2.375, as in the paper
+					if got < prev && s != SquashPLAN || got < 0 || got > ONE {
+						t.Fatalf("%v %v: sigmoid(%v) = %v after %v", f, s, toFloat(z), toFloat(got), toFloat(prev))
+					}
+					prev = got
+					if s != SquashExact && s != SquashHard && fn(0, 0, -z)+got != ONE {
+						t.Fatalf("%v %v: sigmoid(%v) + sigmoid(%v) = %v", f, s, toFloat(z), -toFloat(z), toFloat(fn(0, 0, -z)+got))
+					}
+				}
+				if got := fn(0, 0, MIN); got < 0 || got > ONE>>6 {
+					t.Errorf("%v %v: sigmoid(MIN) = %v", f, s, toFloat(got))
+				}
+				if got := fn(0, 0, MAX); got > ONE || got < ONE-ONE>>6 {
+					t.Errorf("%v %v: sigmoid(MAX) = %v", f, s, toFloat(got))
+				}
+			}
+		})
+	}
+}
+
+// TestSquashError checks SquashError against the same comparison made
+// here, and against the bounds of the Squash docs.
+func TestSquashError(t *testing.T) {
+	for _, f := range []Format{Q16_48, Q4_12, Q8_8} {
+		withFormat(t, f, Wrap, RoundHalfEven, func() {
+			limit := MAX
+			if f.IntBits > 4 {
+				limit = 8 * ONE
+			}
+			step := fixedMax(ONE>>10, 1)
+			for s := Squash(0); s < numSquashes; s++ {
+				var want fixed
+				var sum float64
+				var n int
+				for x := -limit; x <= limit-step; x += step {
+					d := s.fn()(0, 0, x) - sigmoid(0, 0, x)
+					if d < 0 {
+						d = -d
+					}
+					want = fixedMax(want, d)
+					sum += float64(d)
+					n++
+				}
+				max, mean := SquashError(s)
+				if max != want {
+					t.Errorf("%v %v: SquashError max = %v, want %v", f, s, toFloat(max), toFloat(want))
+				}
+				if m := sum / float64(n); math.Abs(float64(mean)-m) > 1 {
+					t.Errorf("%v %v: SquashError mean = %v ulp, want %v", f, s, mean, m)
+				}
+				if s == SquashExact && max != 0 {
+					t.Errorf("%v: exact sigmoid differs from itself by %v", f, toFloat(max))
+				}
+				if b := squashBounds[s].value + 4*toFloat(1); toFloat(max) > b {
+					t.Errorf("%v %v: SquashError max %v above %v", f, s, toFloat(max), b)
+				}
+			}
+		})
+	}
+}
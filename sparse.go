@@ -0,0 +1,201 @@
+package main
+
+// Sparse is a matrix that stores only its non-zero elements, compressed by
+// rows (CSR) or by columns (CSC). It is made from a dense matrix and is read
+// only, for weights that have been pruned.
+type Sparse struct {
+	row, col int
+	// csc is set if ptr runs over columns rather than rows
+	csc bool
+	// the elements of row (or column) i are data[ptr[i]:ptr[i+1]], in
+	// columns (or rows) idx[ptr[i]:ptr[i+1]], which ascend
+	ptr  []int
+	idx  []int
+	data []fixed
+}
+
+// ProductInto works out the product in fixed point, whatever dst is.
+func (a *Sparse) ProductInto(dst Mat, acc Accumulator, b Mat) error {
+	if m, ok := dst.(*Matrix); ok {
+		return m.SparseProductWith(acc, a, AsMatrix(b))
+	}
+	r, c := dst.Dims()
+	m := NewMatrix(r, c, nil)
+	if err := m.SparseProductWith(acc, a, AsMatrix(b)); err != nil {
+		return err
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			dst.Set(i, j, m.at(i, j))
+		}
+	}
+	return nil
+}
+
+// NewCSR compresses the rows of m, dropping every element whose magnitude
+// is at most threshold; a threshold of zero keeps all non-zero elements.
+func NewCSR(m *Matrix, threshold fixed) *Sparse {
+	return compress(m, threshold, false)
+}
+
+// NewCSC is NewCSR compressing the columns of m.
+func NewCSC(m *Matrix, threshold fixed) *Sparse {
+	return compress(m, threshold, true)
+}
+
+func compress(m *Matrix, threshold fixed, csc bool) *Sparse {
+	if threshold < 0 {
+		threshold = 0
+	}
+	s := &Sparse{row: m.row, col: m.col, csc: csc}
+	outer, inner := m.row, m.col
+	if csc {
+		outer, inner = inner, outer
+	}
+	s.ptr = make([]int, outer+1)
+	for i := 0; i < outer; i++ {
+		for j := 0; j < inner; j++ {
+			var x fixed
+			if csc {
+				x = m.at(j, i)
+			} else {
+				x = m.at(i, j)
+			}
+			if magnitude(x) > uint64(threshold) {
+				s.idx = append(s.idx, j)
+				s.data = append(s.data, x)
+			}
+		}
+		s.ptr[i+1] = len(s.data)
+	}
+	return s
+}
+
+func (s *Sparse) Dims() (r, c int) {
+	return s.row, s.col
+}
+
+// NNZ is the number of elements s stores.
+func (s *Sparse) NNZ() int {
+	return len(s.data)
+}
+
+func (s *Sparse) At(r, c int) fixed {
+	v, err := s.AtErr(r, c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (s *Sparse) AtErr(r, c int) (fixed, error) {
+	if r < 0 || r >= s.row || c < 0 || c >= s.col {
+		return 0, &IndexError{Op: "At", Row: r, Col: c, Rows: s.row, Cols: s.col}
+	}
+	return s.at(r, c), nil
+}
+
+func (s *Sparse) at(r, c int) fixed {
+	if s.csc {
+		r, c = c, r
+	}
+	// binary search of the ascending indices of row r
+	lo, hi := s.ptr[r], s.ptr[r+1]
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if s.idx[mid] < c {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < s.ptr[r+1] && s.idx[lo] == c {
+		return s.data[lo]
+	}
+	return 0
+}
+
+// T returns the transpose of s, sharing its storage: the CSR form of a
+// matrix is the CSC form of its transpose.
+func (s *Sparse) T() *Sparse {
+	return &Sparse{row: s.col, col: s.row, csc: !s.csc, ptr: s.ptr, idx: s.idx, data: s.data}
+}
+
+// Dense expands s into a new dense matrix.
+func (s *Sparse) Dense() *Matrix {
+	m := NewMatrix(s.row, s.col, nil)
+	for i := 0; i+1 < len(s.ptr); i++ {
+		for k := s.ptr[i]; k < s.ptr[i+1]; k++ {
+			if s.csc {
+				m.set(s.idx[k], i, s.data[k])
+			} else {
+				m.set(i, s.idx[k], s.data[k])
+			}
+		}
+	}
+	return m
+}
+
+func (m *Matrix) SparseProduct(a *Sparse, b *Matrix) {
+	if err := m.SparseProductErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+// SparseProductErr sets m to a·b, visiting only the elements a stores.
+func (m *Matrix) SparseProductErr(a *Sparse, b *Matrix) error {
+	return m.SparseProductWith(Narrow, a, b)
+}
+
+// SparseProductWith is SparseProductErr summing with acc. Every element sums
+// its products in order of k, skipping the zeros of a as Product does, so
+// the result and the overflow counts are those of Product with a.Dense().
+// As with Product, m must not share storage with b.
+func (m *Matrix) SparseProductWith(acc Accumulator, a *Sparse, b *Matrix) error {
+	br, bc := b.Dims()
+	if a.col != br {
+		return &ShapeError{Op: "SparseProduct", A: [2]int{a.row, a.col}, B: [2]int{br, bc}}
+	}
+	if err := m.holds("SparseProduct", a.row, bc); err != nil {
+		return err
+	}
+
+	var wide []wideSum
+	if acc == Wide {
+		wide = make([]wideSum, a.row*bc)
+	} else {
+		for i := 0; i < m.row; i++ {
+			for j := 0; j < bc; j++ {
+				m.set(i, j, 0)
+			}
+		}
+	}
+	// madd adds x times row k of b to row i of the result
+	madd := func(i, k int, x fixed) {
+		for j := 0; j < bc; j++ {
+			if wide != nil {
+				wide[i*bc+j].add(mul128(x, b.at(k, j)))
+			} else {
+				m.set(i, j, addFixed(m.at(i, j), MultiplyFixed(x, b.at(k, j)), opProduct))
+			}
+		}
+	}
+	// either way round the products reach every result row in order of k
+	for o := 0; o+1 < len(a.ptr); o++ {
+		for p := a.ptr[o]; p < a.ptr[o+1]; p++ {
+			if a.csc {
+				madd(a.idx[p], o, a.data[p])
+			} else {
+				madd(o, a.idx[p], a.data[p])
+			}
+		}
+	}
+	if wide != nil {
+		for i := 0; i < a.row; i++ {
+			for j := 0; j < bc; j++ {
+				m.set(i, j, wide[i*bc+j].fixed(opProduct, rounding))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// sparseOperand returns a random r x c matrix with about density of its
+// elements non-zero, and some of those within a few ulps of zero.
+func sparseOperand(rng *rand.Rand, r, c int, density float64) *Matrix {
+	m := NewMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			switch x := rng.Float64(); {
+			case x < density/4:
+				m.Set(i, j, fixed(rng.Intn(9)-4))
+			case x < density:
+				m.Set(i, j, randomFixed(rng))
+			}
+		}
+	}
+	return m
+}
+
+func TestSparseRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for _, s := range []struct{ r, c int }{{1, 1}, {5, 3}, {17, 40}, {0, 4}} {
+		m := sparseOperand(rng, s.r, s.c, 0.3)
+		for _, threshold := range []fixed{-1, 0, 2, ONE} {
+			// what each form keeps
+			want := Copy(m)
+			for i := 0; i < s.r; i++ {
+				for j := 0; j < s.c; j++ {
+					if x := m.At(i, j); x <= threshold && x >= -threshold {
+						want.Set(i, j, 0)
+					}
+				}
+			}
+			for name, sp := range map[string]*Sparse{"CSR": NewCSR(m, threshold), "CSC": NewCSC(m, threshold)} {
+				name := fmt.Sprintf("%dx%d %s threshold %d", s.r, s.c, name, threshold)
+				if !equal(sp.Dense(), want) {
+					t.Errorf("%s: Dense differs from the matrix", name)
+				}
+				var nnz int
+				for i := 0; i < s.r; i++ {
+					for j := 0; j < s.c; j++ {
+						if sp.At(i, j) != want.At(i, j) {
+							t.Fatalf("%s: At(%d, %d) = %d, want %d", name, i, j, sp.At(i, j), want.At(i, j))
+						}
+						if want.At(i, j) != 0 {
+							nnz++
+						}
+					}
+				}
+				if sp.NNZ() != nnz {
+					t.Errorf("%s: NNZ = %d, want %d", name, sp.NNZ(), nnz)
+				}
+				if !equal(sp.T().Dense(), want.T()) {
+					t.Errorf("%s: T differs from the transpose", name)
+				}
+			}
+		}
+	}
+
+	sp := NewCSR(NewMatrix(2, 3, nil), 0)
+	var ie *IndexError
+	if _, err := sp.AtErr(2, 0); !errors.As(err, &ie) {
+		t.Errorf("AtErr(2, 0) of 2x3 gave %v, want an *IndexError", err)
+	}
+}
+
+// TestSparseProduct checks SparseProductWith against the dense product of
+// the stored elements, including the overflow counts, which it promises to
+// match.
+func TestSparseProduct(t *testing.T) {
+	rng := rand.New(rand.NewSource(12))
+	defer ResetCounters()
+	for _, f := range []Format{Q16_48, Q8_8} {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			withFormat(t, f, a, RoundHalfEven, func() {
+				for _, s := range []struct{ r, k, c int }{{1, 1, 1}, {4, 7, 3}, {33, 20, 9}} {
+					x := sparseOperand(rng, s.r, s.k, 0.4)
+					y := gemmOperand(rng, s.k, s.c, false, true)
+					for _, acc := range []Accumulator{Narrow, Wide} {
+						for name, sp := range map[string]*Sparse{
+							"CSR":   NewCSR(x, 2),
+							"CSC":   NewCSC(x, 2),
+							"CSC T": NewCSR(Copy(x.T()), 2).T(),
+						} {
+							name := fmt.Sprintf("%v %v %dx%dx%d %s %v", f, a, s.r, s.k, s.c, name, acc)
+							want := NewMatrix(s.r, s.c, nil)
+							ResetCounters()
+							if err := want.ProductWith(acc, sp.Dense(), false, y, false); err != nil {
+								t.Fatal(err)
+							}
+							wantCount := ReadCounters()[opProduct]
+
+							got := NewMatrix(s.r, s.c, randomArray(s.r*s.c, 1))
+							ResetCounters()
+							if err := got.SparseProductWith(acc, sp, y); err != nil {
+								t.Fatalf("%s: %v", name, err)
+							}
+							if !equal(got, want) {
+								t.Fatalf("%s: differs from the dense product", name)
+							}
+							if c := ReadCounters()[opProduct]; c != wantCount {
+								t.Fatalf("%s: counted %+v, want %+v", name, c, wantCount)
+							}
+
+							// and into the float backend through Multiplier, which
+							// holds the float64 nearest each element
+							fl := NewFloatMatrix(s.r, s.c, nil)
+							if err := sp.ProductInto(fl, acc, y); err != nil {
+								t.Fatalf("%s: ProductInto: %v", name, err)
+							}
+							wd, _ := ToDense(want)
+							if i, j, ok := closeTo(fl, wd, 0); !ok {
+								t.Fatalf("%s: ProductInto a FloatMatrix (%d, %d) = %v, want %v", name, i, j, fl.AtFloat(i, j), want.AtFloat(i, j))
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+
+	sp := NewCSR(NewMatrix(2, 3, nil), 0)
+	if err := NewMatrix(2, 2, nil).SparseProductErr(sp, NewMatrix(2, 2, nil)); !errors.Is(err, ErrShape) {
+		t.Errorf("2x3 times 2x2 gave %v, want a shape error", err)
+	}
+	if err := NewMatrix(3, 2, nil).SparseProductErr(sp, NewMatrix(3, 2, nil)); !errors.Is(err, ErrShape) {
+		t.Errorf("a 2x2 product into 3x2 gave %v, want a shape error", err)
+	}
+}
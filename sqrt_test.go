@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// refSqrt is the square root of n rounded with r, as sqrt128 describes.
+func refSqrt(n *big.Int, r Rounding) *big.Int {
+	x := new(big.Int).Sqrt(n)
+	// round up when n > x*x + x
+	if r != Truncate && n.Cmp(new(big.Int).Add(new(big.Int).Mul(x, x), x)) > 0 {
+		x.Add(x, big.NewInt(1))
+	}
+	return x
+}
+
+// refRsqrt is 2^(3*fracBits) / x square rooted, 1/sqrt(x) in ulps for x in
+// ulps, to well beyond an ulp.
+func refRsqrt(x *big.Int) float64 {
+	num := new(big.Float).SetPrec(256).SetMantExp(big.NewFloat(1), 3*int(fracBits))
+	q := num.Quo(num, new(big.Float).SetPrec(256).SetInt(x))
+	v, _ := q.Sqrt(q).Float64()
+	return v
+}
+
+func TestSqrt128(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	check := func(hi, lo uint64) {
+		n := new(big.Int).Lsh(new(big.Int).SetUint64(hi), 64)
+		n.Or(n, new(big.Int).SetUint64(lo))
+		for _, r := range testRoundings {
+			if got, want := sqrt128(hi, lo, r), refSqrt(n, r); got != want.Uint64() {
+				t.Fatalf("%v: sqrt128(%x:%x) = %d, want %d", r, hi, lo, got, want)
+			}
+		}
+	}
+	for _, lo := range []uint64{0, 1, 2, 3, 4, 1<<64 - 1} {
+		check(0, lo)
+		check(1<<62-1, lo)
+	}
+	for i := 0; i < 100000; i++ {
+		hi := rng.Uint64() >> uint(2+rng.Intn(63))
+		check(hi, rng.Uint64())
+		// around a perfect square and the midpoints either side of it
+		x := rng.Uint64() >> uint(1+rng.Intn(64))
+		sh, sl := bits.Mul64(x, x)
+		sl, c := bits.Add64(sl, uint64(rng.Intn(3)), 0)
+		check(sh+c, sl)
+		sh, sl = bits.Mul64(x, x+1)
+		check(sh, sl)
+	}
+}
+
+// TestSqrt checks sqrt against the exact root of x << fracBits for each
+// rounding mode, and rsqrt and rsqrtN against the bounds in their docs.
+func TestSqrt(t *testing.T) {
+	rng := rand.New(rand.NewSource(10))
+	defer ResetCounters()
+	for _, f := range testFormats {
+		for _, r := range testRoundings {
+			withFormat(t, f, Saturate, r, func() {
+				for i := 0; i < 20000; i++ {
+					x := randomFixed(rng)
+					if x < 0 {
+						x = -x
+					}
+					want := refSqrt(new(big.Int).Lsh(big.NewInt(int64(x)), fracBits), r)
+					if got := sqrt(x); got != fixed(want.Int64()) {
+						t.Fatalf("%v %v: sqrt(%d) = %d, want %d", f, r, x, got, want)
+					}
+					// and math.Sqrt, to an ulp and the precision of a float64
+					want64 := math.Sqrt(toFloat(x))
+					if e := math.Abs(toFloat(sqrt(x)) - want64); e > toFloat(1)+want64*0x1p-52 {
+						t.Fatalf("%v %v: sqrt(%v) is %g from math.Sqrt", f, r, toFloat(x), e)
+					}
+
+					if x == 0 {
+						continue
+					}
+					got, ref := rsqrt(x), refRsqrt(big.NewInt(int64(x)))
+					if ref > float64(MAX) {
+						if got != MAX {
+							t.Fatalf("%v %v: rsqrt(%d) = %d, want MAX", f, r, x, got)
+						}
+						continue
+					}
+					// the error of sqrt(x) against the exact root s0 moves
+					// ONE / sqrt(x) by ONE^2 / (sqrt(x) * s0) times as much,
+					// about ONE / x
+					m := float64(ONE) * float64(ONE) / (float64(sqrt(x)) * math.Sqrt(float64(x)*float64(ONE)))
+					bound := math.Max(1, 0.5+m/2)
+					if r == Truncate {
+						bound = math.Max(1, m)
+					}
+					if e := math.Abs(float64(got) - ref); e > bound+ref*0x1p-52 {
+						t.Fatalf("%v %v: rsqrt(%d) = %d, want %v: error %v ulp, bound %v", f, r, x, got, ref, e, bound)
+					}
+				}
+
+				for _, n := range []int{1, 2, 3, 784, 1 << 20, 1<<62 + 1} {
+					want := refRsqrt(new(big.Int).Lsh(big.NewInt(int64(n)), fracBits))
+					if got := rsqrtN(n); math.Abs(float64(got)-want) > 1 {
+						t.Errorf("%v %v: rsqrtN(%d) = %d, want %v", f, r, n, got, want)
+					}
+				}
+
+				for _, c := range []struct {
+					name string
+					fn   func() fixed
+					want fixed
+					up   bool
+				}{
+					{"sqrt(-1 ulp)", func() fixed { return sqrt(-1) }, 0, false},
+					{"rsqrt(-1)", func() fixed { return rsqrt(-ONE) }, 0, false},
+					{"rsqrtN(-1)", func() fixed { return rsqrtN(-1) }, 0, false},
+					{"rsqrtN(0)", func() fixed { return rsqrtN(0) }, MAX, true},
+				} {
+					ResetCounters()
+					got := c.fn()
+					n := ReadCounters()[opSqrt]
+					if got != c.want || c.up && n.Overflow != 1 || !c.up && n.Underflow != 1 {
+						t.Errorf("%v %v: %s = %d, counted %+v", f, r, c.name, got, n)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+// Structural operations build new matrices out of existing ones. Like the
+// arithmetic they come in pairs, the Err version returning a *ShapeError and
+// the plain one panicking with it.
+
+func HConcat(ms ...*Matrix) *Matrix {
+	o, err := HConcatErr(ms...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// HConcatErr puts matrices with the same number of rows side by side.
+func HConcatErr(ms ...*Matrix) (*Matrix, error) {
+	if len(ms) == 0 {
+		return NewMatrix(0, 0, nil), nil
+	}
+	r, c := ms[0].row, 0
+	for _, m := range ms {
+		if m.row != r {
+			return nil, &ShapeError{Op: "HConcat", A: [2]int{r, ms[0].col}, B: [2]int{m.row, m.col}}
+		}
+		c += m.col
+	}
+	o := NewMatrix(r, c, nil)
+	j := 0
+	for _, m := range ms {
+		for i := 0; i < r; i++ {
+			m.copyRow(o.data[i*c+j:i*c+j+m.col], i)
+		}
+		j += m.col
+	}
+	return o, nil
+}
+
+func VConcat(ms ...*Matrix) *Matrix {
+	o, err := VConcatErr(ms...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// VConcatErr puts matrices with the same number of columns one above the
+// other.
+func VConcatErr(ms ...*Matrix) (*Matrix, error) {
+	if len(ms) == 0 {
+		return NewMatrix(0, 0, nil), nil
+	}
+	r, c := 0, ms[0].col
+	for _, m := range ms {
+		if m.col != c {
+			return nil, &ShapeError{Op: "VConcat", A: [2]int{ms[0].row, c}, B: [2]int{m.row, m.col}}
+		}
+		r += m.row
+	}
+	o := NewMatrix(r, c, nil)
+	i := 0
+	for _, m := range ms {
+		for k := 0; k < m.row; k++ {
+			m.copyRow(o.data[(i+k)*c:(i+k+1)*c], k)
+		}
+		i += m.row
+	}
+	return o, nil
+}
+
+func Stack(vs ...*Matrix) *Matrix {
+	o, err := StackErr(vs...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// StackErr makes a batch out of column vectors of the same length, vector j
+// becoming column j.
+func StackErr(vs ...*Matrix) (*Matrix, error) {
+	for _, v := range vs {
+		if v.col != 1 {
+			return nil, &ShapeError{Op: "Stack", A: [2]int{vs[0].row, 1}, B: [2]int{v.row, v.col}}
+		}
+	}
+	return HConcatErr(vs...)
+}
+
+func (m *Matrix) SliceRows(i, j int) *Matrix {
+	v, err := m.SliceRowsErr(i, j)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// SliceRowsErr is a view of rows i to j-1 of m.
+func (m *Matrix) SliceRowsErr(i, j int) (*Matrix, error) {
+	return m.ViewErr(i, 0, j-i, m.col)
+}
+
+func (m *Matrix) SliceCols(i, j int) *Matrix {
+	v, err := m.SliceColsErr(i, j)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// SliceColsErr is a view of columns i to j-1 of m.
+func (m *Matrix) SliceColsErr(i, j int) (*Matrix, error) {
+	return m.ViewErr(0, i, m.row, j-i)
+}
+
+func Pad(m *Matrix, top, bottom, left, right int, v fixed) *Matrix {
+	o, err := PadErr(m, top, bottom, left, right, v)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// PadErr surrounds m with top and bottom rows and left and right columns
+// of v.
+func PadErr(m *Matrix, top, bottom, left, right int, v fixed) (*Matrix, error) {
+	if top < 0 || bottom < 0 || left < 0 || right < 0 {
+		return nil, &ShapeError{Op: "Pad", A: [2]int{m.row, m.col}, B: [2]int{top + m.row + bottom, left + m.col + right}}
+	}
+	r, c := top+m.row+bottom, left+m.col+right
+	o := NewMatrix(r, c, nil)
+	if v != 0 {
+		for i := range o.data {
+			o.data[i] = v
+		}
+	}
+	for i := 0; i < m.row; i++ {
+		start := (top+i)*c + left
+		m.copyRow(o.data[start:start+m.col], i)
+	}
+	return o, nil
+}
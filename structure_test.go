@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// counting returns an r x c matrix whose element (i, j) is 10*i + j.
+func counting(r, c int) *Matrix {
+	m := NewMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.Set(i, j, fixed(10*i+j))
+		}
+	}
+	return m
+}
+
+// hasElements reports whether m is len(rows) x len(rows[0]) and holds rows.
+func hasElements(m *Matrix, rows [][]fixed) bool {
+	return equal(m, NewMatrix(len(rows), len(rows[0]), concatRows(rows)))
+}
+
+func concatRows(rows [][]fixed) []fixed {
+	var d []fixed
+	for _, r := range rows {
+		d = append(d, r...)
+	}
+	return d
+}
+
+// TestResize checks that Resize changes the shape, which it used to leave
+// as it was, keeps the common elements and detaches m from any parent.
+func TestResize(t *testing.T) {
+	m := counting(2, 3)
+	m.Resize(3, 2)
+	if !hasElements(m, [][]fixed{{0, 1}, {10, 11}, {0, 0}}) {
+		t.Errorf("2x3 resized to 3x2 = %v", Copy(m).data)
+	}
+	m.Resize(1, 4)
+	if !hasElements(m, [][]fixed{{0, 1, 0, 0}}) {
+		t.Errorf("3x2 resized to 1x4 = %v", Copy(m).data)
+	}
+	m.Resize(0, 0)
+	if r, c := m.Dims(); r != 0 || c != 0 {
+		t.Errorf("resized to 0x0 is %dx%d", r, c)
+	}
+
+	p := counting(3, 4)
+	v := p.View(1, 1, 2, 3).T()
+	v.Resize(2, 2)
+	if !hasElements(v, [][]fixed{{11, 21}, {12, 22}}) {
+		t.Errorf("a transposed view resized to 2x2 = %v", Copy(v).data)
+	}
+	v.Set(0, 0, 99)
+	if p.At(1, 1) != 11 {
+		t.Error("a resized view still writes to its parent")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	a := counting(2, 3)
+	b := counting(3, 2).T()
+	c := counting(4, 4).View(1, 2, 2, 1)
+	h := HConcat(a, b, c)
+	want := [][]fixed{{0, 1, 2, 0, 10, 20, 12}, {10, 11, 12, 1, 11, 21, 22}}
+	if !hasElements(h, want) {
+		t.Errorf("HConcat = %v", h.data)
+	}
+	v := VConcat(a.T(), b.T(), c.T())
+	if !equal(v, h.T()) {
+		t.Errorf("VConcat of the transposes = %v, want HConcat transposed", v.data)
+	}
+	s := Stack(a.ColView(2), c, NewMatrix(2, 1, []fixed{7, 8}))
+	if !hasElements(s, [][]fixed{{2, 12, 7}, {12, 22, 8}}) {
+		t.Errorf("Stack = %v", s.data)
+	}
+	// the results are copies
+	h.Set(0, 0, 99)
+	if a.At(0, 0) != 0 {
+		t.Error("HConcat shares data with its operand")
+	}
+
+	var se *ShapeError
+	for name, fn := range map[string]func() error{
+		"HConcat": func() error { _, err := HConcatErr(a, counting(3, 1)); return err },
+		"VConcat": func() error { _, err := VConcatErr(a, counting(1, 2)); return err },
+		"Stack":   func() error { _, err := StackErr(c, a); return err },
+		"Stack of different lengths": func() error {
+			_, err := StackErr(c, counting(3, 1))
+			return err
+		},
+		"SliceRows": func() error { _, err := a.SliceRowsErr(1, 3); return err },
+		"SliceCols": func() error { _, err := a.SliceColsErr(2, 1); return err },
+		"Pad":       func() error { _, err := PadErr(a, 0, -1, 0, 0, 0); return err },
+	} {
+		if err := fn(); !errors.As(err, &se) {
+			t.Errorf("%s gave %v, want a *ShapeError", name, err)
+		}
+	}
+	if e, err := HConcatErr(); err != nil || e.row != 0 || e.col != 0 {
+		t.Errorf("HConcat of nothing = %v, %v", e, err)
+	}
+}
+
+func TestSlicePad(t *testing.T) {
+	m := counting(4, 5)
+	rows := m.SliceRows(1, 3)
+	cols := m.T().SliceCols(2, 4)
+	if !hasElements(rows, [][]fixed{{10, 11, 12, 13, 14}, {20, 21, 22, 23, 24}}) {
+		t.Errorf("SliceRows(1, 3) = %v", Copy(rows).data)
+	}
+	if !equal(cols, m.SliceRows(2, 4).T()) {
+		t.Errorf("SliceCols(2, 4) of the transpose = %v", Copy(cols).data)
+	}
+	// slices are views
+	rows.Set(1, 4, 99)
+	cols.Set(0, 1, 98)
+	if m.At(2, 4) != 99 || m.At(3, 0) != 98 {
+		t.Error("writes through slices did not reach the matrix")
+	}
+	if r, c := m.SliceRows(2, 2).Dims(); r != 0 || c != 5 {
+		t.Errorf("an empty slice is %dx%d", r, c)
+	}
+
+	p := Pad(m.View(0, 0, 2, 2).T(), 1, 2, 0, 1, -ONE)
+	n := -ONE
+	want := [][]fixed{
+		{n, n, n},
+		{0, 10, n},
+		{1, 11, n},
+		{n, n, n},
+		{n, n, n},
+	}
+	if !hasElements(p, want) {
+		t.Errorf("Pad = %v", p.data)
+	}
+	if !equal(Pad(m, 0, 0, 0, 0, ONE), m) {
+		t.Error("Pad by nothing changed the matrix")
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Tensor is an n-dimensional array of fixed values. Element (i0, i1, ...) is
+// at data[offset + i0*stride[0] + i1*stride[1] + ...], so permutes, reshapes
+// of contiguous tensors and conversions to and from Matrix share data
+// without copying. The arithmetic is that of Matrix: products round with
+// the package rounding mode, and overflows wrap or saturate and are counted.
+type Tensor struct {
+	shape  []int
+	stride []int
+	offset int
+	data   []fixed
+}
+
+// TensorShapeError is ShapeError for tensors of any rank.
+type TensorShapeError struct {
+	Op       string
+	A, B     []int
+	Receiver bool
+}
+
+func (e *TensorShapeError) Error() string {
+	if e.Receiver {
+		return fmt.Sprintf("%v in %s: receiver is %v, result is %v", ErrShape, e.Op, e.A, e.B)
+	}
+	return fmt.Sprintf("%v in %s: %v and %v", ErrShape, e.Op, e.A, e.B)
+}
+
+func (e *TensorShapeError) Unwrap() error {
+	return ErrShape
+}
+
+// TensorIndexError is IndexError for tensors of any rank.
+type TensorIndexError struct {
+	Op           string
+	Index, Shape []int
+}
+
+func (e *TensorIndexError) Error() string {
+	return fmt.Sprintf("%v in %s: %v of %v", ErrIndexOutOfRange, e.Op, e.Index, e.Shape)
+}
+
+func (e *TensorIndexError) Unwrap() error {
+	return ErrIndexOutOfRange
+}
+
+func NewTensor(shape []int, data []fixed) *Tensor {
+	t, err := NewTensorErr(shape, data)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// NewTensorErr is NewMatrixErr for any rank: data, when given, must hold
+// the product of shape values in row-major order and becomes the storage.
+func NewTensorErr(shape []int, data []fixed) (*Tensor, error) {
+	n := 1
+	for _, d := range shape {
+		if d < 0 {
+			return nil, &TensorShapeError{Op: "NewTensor", A: shape, B: []int{len(data)}}
+		}
+		n *= d
+	}
+	if data != nil && len(data) != n {
+		return nil, &TensorShapeError{Op: "NewTensor", A: shape, B: []int{len(data)}}
+	}
+	if data == nil {
+		data = make([]fixed, n)
+	}
+	shape = append([]int(nil), shape...)
+	return &Tensor{shape: shape, stride: rowMajor(shape), data: data}, nil
+}
+
+// rowMajor returns the strides of a compact row-major tensor of shape.
+func rowMajor(shape []int) []int {
+	stride := make([]int, len(shape))
+	s := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		stride[i] = s
+		s *= shape[i]
+	}
+	return stride
+}
+
+// Shape returns a copy of the dimensions of t.
+func (t *Tensor) Shape() []int {
+	return append([]int(nil), t.shape...)
+}
+
+func (t *Tensor) Rank() int {
+	return len(t.shape)
+}
+
+// Size is the number of elements of t.
+func (t *Tensor) Size() int {
+	n := 1
+	for _, d := range t.shape {
+		n *= d
+	}
+	return n
+}
+
+func (t *Tensor) index(idx []int) int {
+	o := t.offset
+	for i, x := range idx {
+		o += x * t.stride[i]
+	}
+	return o
+}
+
+func (t *Tensor) inRange(op string, idx []int) error {
+	if len(idx) != len(t.shape) {
+		return &TensorIndexError{Op: op, Index: idx, Shape: t.Shape()}
+	}
+	for i, x := range idx {
+		if x < 0 || x >= t.shape[i] {
+			return &TensorIndexError{Op: op, Index: idx, Shape: t.Shape()}
+		}
+	}
+	return nil
+}
+
+func (t *Tensor) At(idx ...int) fixed {
+	v, err := t.AtErr(idx...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (t *Tensor) AtErr(idx ...int) (fixed, error) {
+	if err := t.inRange("At", idx); err != nil {
+		return 0, err
+	}
+	return t.data[t.index(idx)], nil
+}
+
+// Set takes the value first, since the index is variadic.
+func (t *Tensor) Set(v fixed, idx ...int) {
+	if err := t.SetErr(v, idx...); err != nil {
+		panic(err)
+	}
+}
+
+func (t *Tensor) SetErr(v fixed, idx ...int) error {
+	if err := t.inRange("Set", idx); err != nil {
+		return err
+	}
+	t.data[t.index(idx)] = v
+	return nil
+}
+
+// each calls fn with every index of shape in row-major order; idx is reused
+// between calls.
+func each(shape []int, fn func(idx []int)) {
+	for _, d := range shape {
+		if d == 0 {
+			return
+		}
+	}
+	idx := make([]int, len(shape))
+	for {
+		fn(idx)
+		i := len(idx) - 1
+		for ; i >= 0; i-- {
+			idx[i]++
+			if idx[i] < shape[i] {
+				break
+			}
+			idx[i] = 0
+		}
+		if i < 0 {
+			return
+		}
+	}
+}
+
+// CopyTensor returns a compact row-major copy of t, whatever view t is.
+func CopyTensor(t *Tensor) *Tensor {
+	o := NewTensor(t.shape, nil)
+	i := 0
+	each(t.shape, func(idx []int) {
+		o.data[i] = t.data[t.index(idx)]
+		i++
+	})
+	return o
+}
+
+// contiguous reports whether t is laid out compactly in row-major order.
+func (t *Tensor) contiguous() bool {
+	s := 1
+	for i := len(t.shape) - 1; i >= 0; i-- {
+		if t.shape[i] != 1 && t.stride[i] != s {
+			return false
+		}
+		s *= t.shape[i]
+	}
+	return true
+}
+
+func (t *Tensor) Reshape(shape ...int) *Tensor {
+	o, err := t.ReshapeErr(shape...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// ReshapeErr returns t with the same elements in row-major order in a new
+// shape of the same size. It shares t's data if t is contiguous and copies
+// it otherwise.
+func (t *Tensor) ReshapeErr(shape ...int) (*Tensor, error) {
+	n := 1
+	for _, d := range shape {
+		if d < 0 {
+			n = -1
+			break
+		}
+		n *= d
+	}
+	if n != t.Size() {
+		return nil, &TensorShapeError{Op: "Reshape", A: t.Shape(), B: shape}
+	}
+	src := t
+	if !t.contiguous() {
+		src = CopyTensor(t)
+	}
+	return &Tensor{
+		shape:  append([]int(nil), shape...),
+		stride: rowMajor(shape),
+		offset: src.offset,
+		data:   src.data,
+	}, nil
+}
+
+func (t *Tensor) Permute(axes ...int) *Tensor {
+	o, err := t.PermuteErr(axes...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// PermuteErr returns a view of t with its axes reordered: axis i of the
+// view is axis axes[i] of t. Permute(1, 0) of a rank-2 tensor is its
+// transpose.
+func (t *Tensor) PermuteErr(axes ...int) (*Tensor, error) {
+	if len(axes) != len(t.shape) {
+		return nil, &TensorShapeError{Op: "Permute", A: t.Shape(), B: axes}
+	}
+	seen := make([]bool, len(axes))
+	o := &Tensor{shape: make([]int, len(axes)), stride: make([]int, len(axes)), offset: t.offset, data: t.data}
+	for i, a := range axes {
+		if a < 0 || a >= len(axes) || seen[a] {
+			return nil, &TensorShapeError{Op: "Permute", A: t.Shape(), B: axes}
+		}
+		seen[a] = true
+		o.shape[i], o.stride[i] = t.shape[a], t.stride[a]
+	}
+	return o, nil
+}
+
+// broadcastShape is the shape a and b broadcast to: aligned on their last
+// axes, each pair of dimensions must be equal or one of them 1.
+func broadcastShape(a, b []int) ([]int, bool) {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	shape := make([]int, n)
+	for i := 1; i <= n; i++ {
+		x, y := 1, 1
+		if i <= len(a) {
+			x = a[len(a)-i]
+		}
+		if i <= len(b) {
+			y = b[len(b)-i]
+		}
+		d, ok := broadcastDim(x, y)
+		if !ok {
+			return nil, false
+		}
+		shape[n-i] = d
+	}
+	return shape, true
+}
+
+// broadcastIndex is the offset in t of element idx of a broadcast result,
+// t being aligned on its last axes and repeated along its axes of length 1.
+func (t *Tensor) broadcastIndex(idx []int) int {
+	o := t.offset
+	skip := len(idx) - len(t.shape)
+	for i, d := range t.shape {
+		if d != 1 {
+			o += idx[skip+i] * t.stride[i]
+		}
+	}
+	return o
+}
+
+func (t *Tensor) sameShape(shape []int) bool {
+	if len(t.shape) != len(shape) {
+		return false
+	}
+	for i, d := range shape {
+		if t.shape[i] != d {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Tensor) holds(op string, shape []int) error {
+	if !t.sameShape(shape) {
+		return &TensorShapeError{Op: op, A: t.Shape(), B: shape, Receiver: true}
+	}
+	return nil
+}
+
+func (t *Tensor) Broadcast(fn func(x, y fixed) fixed, a, b *Tensor) {
+	if err := t.BroadcastErr(fn, a, b); err != nil {
+		panic(err)
+	}
+}
+
+// BroadcastErr sets every element of t to fn of the corresponding elements
+// of a and b, broadcast to a common shape as in numpy: aligned on their last
+// axes, an axis of length 1, or a missing one, is repeated to the length of
+// the other. t must have the broadcast shape and not overlap a or b.
+func (t *Tensor) BroadcastErr(fn func(x, y fixed) fixed, a, b *Tensor) error {
+	return t.broadcast("Broadcast", fn, a, b)
+}
+
+func (t *Tensor) Add(a, b *Tensor) {
+	if err := t.AddErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (t *Tensor) AddErr(a, b *Tensor) error {
+	return t.broadcast("Add", func(x, y fixed) fixed { return addFixed(x, y, opAdd) }, a, b)
+}
+
+func (t *Tensor) Sub(a, b *Tensor) {
+	if err := t.SubErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (t *Tensor) SubErr(a, b *Tensor) error {
+	return t.broadcast("Sub", func(x, y fixed) fixed { return subFixed(x, y, opSub) }, a, b)
+}
+
+func (t *Tensor) MulElem(a, b *Tensor) {
+	if err := t.MulElemErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+func (t *Tensor) MulElemErr(a, b *Tensor) error {
+	return t.broadcast("MulElem", MultiplyFixed, a, b)
+}
+
+func (t *Tensor) broadcast(op string, fn func(x, y fixed) fixed, a, b *Tensor) error {
+	shape, ok := broadcastShape(a.shape, b.shape)
+	if !ok {
+		return &TensorShapeError{Op: op, A: a.Shape(), B: b.Shape()}
+	}
+	if err := t.holds(op, shape); err != nil {
+		return err
+	}
+	each(shape, func(idx []int) {
+		t.data[t.index(idx)] = fn(a.data[a.broadcastIndex(idx)], b.data[b.broadcastIndex(idx)])
+	})
+	return nil
+}
+
+func (t *Tensor) Apply(fn func(idx []int, v fixed) fixed, a *Tensor) {
+	if err := t.ApplyErr(fn, a); err != nil {
+		panic(err)
+	}
+}
+
+// ApplyErr sets every element of t to fn of its index and the element of a
+// there; fn must not keep idx.
+func (t *Tensor) ApplyErr(fn func(idx []int, v fixed) fixed, a *Tensor) error {
+	if err := t.holds("Apply", a.shape); err != nil {
+		return err
+	}
+	each(a.shape, func(idx []int) {
+		t.data[t.index(idx)] = fn(idx, a.data[a.index(idx)])
+	})
+	return nil
+}
+
+func (t *Tensor) Scale(c fixed) {
+	each(t.shape, func(idx []int) {
+		i := t.index(idx)
+		t.data[i] = MultiplyFixed(t.data[i], c)
+	})
+}
+
+func (t *Tensor) MatMul(a, b *Tensor) {
+	if err := t.MatMulErr(a, b); err != nil {
+		panic(err)
+	}
+}
+
+// MatMulErr sets t to the batched product of a and b, which must have rank 2
+// or more: the last two axes of each are multiplied as matrices, and the
+// axes before them are broadcast as in BroadcastErr. a of shape [..., n, k]
+// and b of shape [..., k, m] give t of shape [..., n, m].
+func (t *Tensor) MatMulErr(a, b *Tensor) error {
+	return t.MatMulWith(Narrow, a, b)
+}
+
+// MatMulWith is MatMulErr summing with acc. Every batch is worked out by the
+// same code as Product, so each matrix of t is what Product gives. As with
+// Product, t must not share storage with a or b.
+func (t *Tensor) MatMulWith(acc Accumulator, a, b *Tensor) error {
+	ra, rb := len(a.shape), len(b.shape)
+	if ra < 2 || rb < 2 || a.shape[ra-1] != b.shape[rb-2] {
+		return &TensorShapeError{Op: "MatMul", A: a.Shape(), B: b.Shape()}
+	}
+	batch, ok := broadcastShape(a.shape[:ra-2], b.shape[:rb-2])
+	if !ok {
+		return &TensorShapeError{Op: "MatMul", A: a.Shape(), B: b.Shape()}
+	}
+	n, m := a.shape[ra-2], b.shape[rb-1]
+	if err := t.holds("MatMul", append(append([]int(nil), batch...), n, m)); err != nil {
+		return err
+	}
+
+	// the batch index followed by zeros for the row and column axes,
+	// where every matrix starts
+	idx := make([]int, len(batch)+2)
+	var err error
+	each(batch, func(bi []int) {
+		if err != nil {
+			return
+		}
+		copy(idx, bi)
+		am := a.matrixAt(a.broadcastIndex(idx))
+		bm := b.matrixAt(b.broadcastIndex(idx))
+		o := t.index(idx)
+		dst := t.viewAt(o)
+		if dst == nil {
+			dst = NewMatrix(n, m, nil)
+		}
+		if err = dst.gemm("MatMul", acc, am, false, bm, false); err != nil {
+			return
+		}
+		if t.viewAt(o) == nil {
+			t.setMatrixAt(o, dst)
+		}
+	})
+	return err
+}
+
+// viewAt returns the matrix formed by the last two axes of t from offset o,
+// sharing t's data, or nil if neither axis has stride 1 and a Matrix cannot
+// view them.
+func (t *Tensor) viewAt(o int) *Matrix {
+	k := len(t.shape)
+	r, c := t.shape[k-2], t.shape[k-1]
+	rs, cs := t.stride[k-2], t.stride[k-1]
+	switch {
+	case r == 0 || c == 0:
+		return NewMatrix(r, c, nil)
+	case cs == 1 || c == 1:
+		return &Matrix{row: r, col: c, stride: rs, data: t.data[o:]}
+	case rs == 1 || r == 1:
+		return &Matrix{row: r, col: c, stride: cs, trans: true, data: t.data[o:]}
+	}
+	return nil
+}
+
+// matrixAt is viewAt, copying the elements if they cannot be viewed.
+func (t *Tensor) matrixAt(o int) *Matrix {
+	if m := t.viewAt(o); m != nil {
+		return m
+	}
+	k := len(t.shape)
+	r, c := t.shape[k-2], t.shape[k-1]
+	m := NewMatrix(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.set(i, j, t.data[o+i*t.stride[k-2]+j*t.stride[k-1]])
+		}
+	}
+	return m
+}
+
+// setMatrixAt copies m into the last two axes of t from offset o.
+func (t *Tensor) setMatrixAt(o int, m *Matrix) {
+	k := len(t.shape)
+	for i := 0; i < m.row; i++ {
+		for j := 0; j < m.col; j++ {
+			t.data[o+i*t.stride[k-2]+j*t.stride[k-1]] = m.at(i, j)
+		}
+	}
+}
+
+// Tensor returns m as a rank-2 tensor sharing its data.
+func (m *Matrix) Tensor() *Tensor {
+	t := &Tensor{shape: []int{m.row, m.col}, stride: []int{m.stride, 1}, data: m.data}
+	if m.trans {
+		t.stride = []int{1, m.stride}
+	}
+	return t
+}
+
+func (t *Tensor) Matrix() *Matrix {
+	m, err := t.MatrixErr()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// MatrixErr returns a rank-2 t as a Matrix, sharing t's data if one of its
+// axes has stride 1 and copying it otherwise.
+func (t *Tensor) MatrixErr() (*Matrix, error) {
+	if len(t.shape) != 2 {
+		return nil, &TensorShapeError{Op: "Matrix", A: t.Shape(), B: []int{-1, -1}}
+	}
+	if t.Size() == 0 {
+		return NewMatrix(t.shape[0], t.shape[1], nil), nil
+	}
+	return t.matrixAt(t.offset), nil
+}
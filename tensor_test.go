@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// countingTensor returns a tensor of shape whose elements are 0, 1, 2, ...
+// in row-major order.
+func countingTensor(shape ...int) *Tensor {
+	t := NewTensor(shape, nil)
+	for i := range t.data {
+		t.data[i] = fixed(i)
+	}
+	return t
+}
+
+// randomTensor returns a tensor of shape with random elements.
+func randomTensor(rng *rand.Rand, shape ...int) *Tensor {
+	t := NewTensor(shape, nil)
+	for i := range t.data {
+		t.data[i] = randomFixed(rng)
+	}
+	return t
+}
+
+func TestPermuteReshape(t *testing.T) {
+	x := countingTensor(2, 3, 4)
+	p := x.Permute(2, 0, 1)
+	if got := p.Shape(); fmt.Sprint(got) != "[4 2 3]" {
+		t.Fatalf("Permute(2, 0, 1) of [2 3 4] has shape %v", got)
+	}
+	each(p.shape, func(idx []int) {
+		if got, want := p.At(idx...), x.At(idx[1], idx[2], idx[0]); got != want {
+			t.Errorf("Permute(2, 0, 1) at %v = %d, want %d", idx, got, want)
+		}
+	})
+	p.Set(-1, 3, 1, 2)
+	if x.At(1, 2, 3) != -1 {
+		t.Error("a write through a permute did not reach the tensor")
+	}
+	x.Set(23, 1, 2, 3)
+
+	// a contiguous reshape shares data, any other copies
+	r := x.Reshape(6, 4)
+	r.Set(-2, 0, 1)
+	if x.At(0, 0, 1) != -2 {
+		t.Error("Reshape of a contiguous tensor does not share its data")
+	}
+	x.Set(1, 0, 0, 1)
+	pr := p.Reshape(8, 3)
+	var i int
+	each(p.shape, func(idx []int) {
+		if got, want := pr.At(i/3, i%3), p.At(idx...); got != want {
+			t.Errorf("Reshape(8, 3) of the permute at %d = %d, want %d", i, got, want)
+		}
+		i++
+	})
+	pr.Set(-3, 0, 0)
+	if x.At(0, 0, 0) != 0 {
+		t.Error("Reshape of a permute shares its data")
+	}
+	if got := x.Reshape(24).Reshape(2, 3, 4); !equalTensor(got, x) {
+		t.Error("Reshape to rank 1 and back changed the tensor")
+	}
+
+	// a permute of a permute is a permute
+	if !equalTensor(p.Permute(1, 2, 0), x) {
+		t.Error("Permute(1, 2, 0) does not undo Permute(2, 0, 1)")
+	}
+	if !equal(x.Reshape(6, 4).Permute(1, 0).Matrix(), Copy(x.Reshape(6, 4).Matrix()).T()) {
+		t.Error("Permute(1, 0) is not the transpose")
+	}
+
+	var se *TensorShapeError
+	for name, fn := range map[string]func() error{
+		"Permute(0, 0, 1)": func() error { _, err := x.PermuteErr(0, 0, 1); return err },
+		"Permute(0, 1)":    func() error { _, err := x.PermuteErr(0, 1); return err },
+		"Permute(0, 1, 3)": func() error { _, err := x.PermuteErr(0, 1, 3); return err },
+		"Reshape(5, 5)":    func() error { _, err := x.ReshapeErr(5, 5); return err },
+		"Reshape(-4, -6)":  func() error { _, err := x.ReshapeErr(-4, -6); return err },
+	} {
+		if err := fn(); !errors.As(err, &se) || !errors.Is(err, ErrShape) {
+			t.Errorf("%s of [2 3 4] gave %v, want a *TensorShapeError", name, err)
+		}
+	}
+	if _, err := x.AtErr(0, 3, 0); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("AtErr(0, 3, 0) of [2 3 4] gave %v, want an index error", err)
+	}
+}
+
+// equalTensor reports whether a and b have the same shape and elements.
+func equalTensor(a, b *Tensor) bool {
+	if !a.sameShape(b.shape) {
+		return false
+	}
+	ok := true
+	each(a.shape, func(idx []int) {
+		ok = ok && a.At(idx...) == b.At(idx...)
+	})
+	return ok
+}
+
+func TestTensorBroadcast(t *testing.T) {
+	rng := rand.New(rand.NewSource(17))
+	for _, c := range []struct {
+		a, b, want []int
+	}{
+		{[]int{2, 1, 3}, []int{4, 1}, []int{2, 4, 3}},
+		{[]int{3}, []int{2, 3}, []int{2, 3}},
+		{[]int{1}, []int{2, 2}, []int{2, 2}},
+		{[]int{2, 3}, []int{2, 3}, []int{2, 3}},
+		{[]int{0, 1}, []int{1, 3}, []int{0, 3}},
+	} {
+		a, b := randomTensor(rng, c.a...), randomTensor(rng, c.b...)
+		for _, o := range []struct {
+			name string
+			fn   func(t *Tensor) error
+			ref  func(x, y fixed) fixed
+		}{
+			{"Add", func(t *Tensor) error { return t.AddErr(a, b) }, func(x, y fixed) fixed { return addFixed(x, y, opAdd) }},
+			{"Sub", func(t *Tensor) error { return t.SubErr(a, b) }, func(x, y fixed) fixed { return subFixed(x, y, opSub) }},
+			{"MulElem", func(t *Tensor) error { return t.MulElemErr(a, b) }, MultiplyFixed},
+		} {
+			got := NewTensor(c.want, nil)
+			if err := o.fn(got); err != nil {
+				t.Fatalf("%s of %v and %v: %v", o.name, c.a, c.b, err)
+			}
+			each(c.want, func(idx []int) {
+				// the indices of a and b, aligned on the last axes and 0
+				// along the axes they repeat
+				at := func(x *Tensor) fixed {
+					xi := append([]int(nil), idx[len(idx)-x.Rank():]...)
+					for i, d := range x.shape {
+						if d == 1 {
+							xi[i] = 0
+						}
+					}
+					return x.At(xi...)
+				}
+				if g, w := got.At(idx...), o.ref(at(a), at(b)); g != w {
+					t.Fatalf("%s of %v and %v at %v = %d, want %d", o.name, c.a, c.b, idx, g, w)
+				}
+			})
+		}
+	}
+
+	// into a permuted receiver
+	a, b := randomTensor(rng, 3, 4), randomTensor(rng, 4)
+	want := NewTensor([]int{3, 4}, nil)
+	want.Add(a, b)
+	got := NewTensor([]int{4, 3}, nil)
+	got.Permute(1, 0).Add(a, b)
+	if !equalTensor(got.Permute(1, 0), want) {
+		t.Error("Add into a permuted tensor differs")
+	}
+
+	x := NewTensor([]int{2, 3}, nil)
+	for name, err := range map[string]error{
+		"[2 3] + [2]":                x.AddErr(x, NewTensor([]int{2}, nil)),
+		"[2 3] + [3] into [3 2]":     NewTensor([]int{3, 2}, nil).AddErr(x, NewTensor([]int{3}, nil)),
+		"[2 3] * [2 1 3] into [2 3]": x.MulElemErr(x, NewTensor([]int{2, 1, 3}, nil)),
+	} {
+		if !errors.Is(err, ErrShape) {
+			t.Errorf("%s gave %v, want a shape error", name, err)
+		}
+	}
+}
+
+// TestMatMul checks every matrix of a batched product against Product of
+// the matching matrices of its operands, with broadcast batch axes,
+// permuted operands and a receiver that a Matrix cannot view.
+func TestMatMul(t *testing.T) {
+	rng := rand.New(rand.NewSource(18))
+	defer ResetCounters()
+	for _, f := range []Format{Q16_48, Q8_8} {
+		withFormat(t, f, Saturate, RoundHalfEven, func() {
+			a := randomTensor(rng, 2, 1, 3, 4)
+			// b as [3, 4, 5], stored with its last axes swapped
+			b := randomTensor(rng, 3, 5, 4).Permute(0, 2, 1)
+			// the result as [2, 3, 3, 5], stored with neither of its last
+			// axes of stride 1
+			store := NewTensor([]int{3, 5, 2, 3}, nil)
+			for _, acc := range []Accumulator{Narrow, Wide} {
+				for name, dst := range map[string]*Tensor{
+					"compact":  NewTensor([]int{2, 3, 3, 5}, nil),
+					"permuted": store.Permute(2, 3, 0, 1),
+				} {
+					ResetCounters()
+					if err := dst.MatMulWith(acc, a, b); err != nil {
+						t.Fatalf("%v %v %s: %v", f, acc, name, err)
+					}
+					counts := ReadCounters()[opProduct]
+					var wantCounts OpCount
+					for i := 0; i < 2; i++ {
+						for j := 0; j < 3; j++ {
+							am := CopyTensor(a).Reshape(2, 3, 4).matrixAt(i * 12)
+							bm := CopyTensor(b).matrixAt(j * 20)
+							want := NewMatrix(3, 5, nil)
+							ResetCounters()
+							if err := want.ProductWith(acc, am, false, bm, false); err != nil {
+								t.Fatal(err)
+							}
+							c := ReadCounters()[opProduct]
+							wantCounts.Overflow += c.Overflow
+							wantCounts.Underflow += c.Underflow
+							for r := 0; r < 3; r++ {
+								for k := 0; k < 5; k++ {
+									if got := dst.At(i, j, r, k); got != want.At(r, k) {
+										t.Fatalf("%v %v %s: [%d %d %d %d] = %d, want %d", f, acc, name, i, j, r, k, got, want.At(r, k))
+									}
+								}
+							}
+						}
+					}
+					if counts != wantCounts {
+						t.Errorf("%v %v %s: counted %+v, want %+v", f, acc, name, counts, wantCounts)
+					}
+				}
+			}
+		})
+	}
+
+	a := countingTensor(2, 3)
+	for name, err := range map[string]error{
+		"rank 1":         NewTensor([]int{2}, nil).MatMulErr(NewTensor([]int{3}, nil), a),
+		"inner mismatch": NewTensor([]int{2, 3}, nil).MatMulErr(a, a),
+		"batch mismatch": NewTensor([]int{3, 2, 2}, nil).MatMulErr(countingTensor(2, 2, 3), countingTensor(3, 3, 2)),
+		"receiver":       NewTensor([]int{2, 3}, nil).MatMulErr(a, countingTensor(3, 2)),
+	} {
+		if !errors.Is(err, ErrShape) {
+			t.Errorf("MatMul with %s gave %v, want a shape error", name, err)
+		}
+	}
+}
+
+func TestTensorMatrix(t *testing.T) {
+	m := counting(4, 5)
+	for name, v := range map[string]*Matrix{
+		"matrix":          m,
+		"view":            m.View(1, 1, 2, 3),
+		"transposed view": m.View(1, 1, 2, 3).T(),
+	} {
+		x := v.Tensor()
+		r, c := v.Dims()
+		if fmt.Sprint(x.Shape()) != fmt.Sprint([]int{r, c}) {
+			t.Errorf("%s: Tensor has shape %v", name, x.Shape())
+		}
+		if !equal(x.Matrix(), v) {
+			t.Errorf("%s: Tensor().Matrix() differs", name)
+		}
+		x.Set(-1, r-1, c-1)
+		if v.At(r-1, c-1) != -1 {
+			t.Errorf("%s: Tensor does not share the matrix's data", name)
+		}
+		v.Set(r-1, c-1, 1)
+	}
+	if _, err := countingTensor(2, 2, 2).MatrixErr(); !errors.Is(err, ErrShape) {
+		t.Errorf("MatrixErr of rank 3 gave %v, want a shape error", err)
+	}
+}
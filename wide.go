@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// Rounding selects how a result with more fractional bits than the active
+// Format is brought back to it.
+type Rounding int
+
+const (
+	// Truncate drops the extra bits, which rounds toward minus infinity in
+	// two's complement.
+	Truncate Rounding = iota
+	// RoundHalfUp rounds to nearest, ties toward plus infinity.
+	RoundHalfUp
+	// RoundHalfEven rounds to nearest, ties to the even neighbour.
+	RoundHalfEven
+)
+
+var rounding = RoundHalfEven
+
+func SetRounding(r Rounding) {
+	rounding = r
+}
+
+func (r Rounding) String() string {
+	switch r {
+	case Truncate:
+		return "truncate"
+	case RoundHalfUp:
+		return "half-up"
+	}
+	return "half-even"
+}
+
+// int128 is a two's complement 128-bit integer.
+type int128 struct {
+	hi, lo uint64
+}
+
+func mul128(a, b fixed) int128 {
+	hi, lo := bits.Mul64(magnitude(a), magnitude(b))
+	x := int128{hi, lo}
+	if (a < 0) != (b < 0) {
+		x = x.neg()
+	}
+	return x
+}
+
+func (x int128) neg() int128 {
+	lo, carry := bits.Add64(^x.lo, 1, 0)
+	return int128{^x.hi + carry, lo}
+}
+
+func (x int128) add(y int128) int128 {
+	lo, carry := bits.Add64(x.lo, y.lo, 0)
+	hi, _ := bits.Add64(x.hi, y.hi, carry)
+	return int128{hi, lo}
+}
+
+func (x int128) negative() bool {
+	return int64(x.hi) < 0
+}
+
+// shr shifts x right by n (0 < n < 64) bits, rounding the dropped bits
+// away according to r.
+func (x int128) shr(n uint, r Rounding) int128 {
+	q := int128{uint64(int64(x.hi) >> n), x.lo>>n | x.hi<<(64-n)}
+	rem := x.lo & (uint64(1)<<n - 1)
+	half := uint64(1) << (n - 1)
+	switch {
+	case r == RoundHalfUp && rem >= half,
+		r == RoundHalfEven && (rem > half || rem == half && q.lo&1 == 1):
+		q = q.add(int128{0, 1})
+	}
+	return q
+}
+
+// fixed brings x into the active format, counting an overflow against o if
+// it does not fit.
+func (x int128) fixed(o op) fixed {
+	v, _ := x.fixedErr(o)
+	return v
+}
+
+// fixedErr is fixed, reporting ErrOverflow when x does not fit.
+func (x int128) fixedErr(o op) (fixed, error) {
+	v := fixed(x.lo)
+	if x.hi != uint64(int64(v)>>63) || v > MAX || v < MIN {
+		return overflowed(o, !x.negative(), v), ErrOverflow
+	}
+	return v, nil
+}
+
+// roundsUp decides whether the magnitude q of a quotient with remainder rem
+// over d goes up by one under r; neg gives the sign of the quotient, since
+// Truncate and RoundHalfUp round magnitudes differently on either side of
+// zero.
+func roundsUp(q, rem, d uint64, neg bool, r Rounding) bool {
+	switch r {
+	case Truncate:
+		return neg && rem != 0
+	case RoundHalfUp:
+		return rem > d-rem || (rem == d-rem && !neg)
+	}
+	return rem > d-rem || (rem == d-rem && q&1 == 1)
+}
+
+// wideSum adds int128 values exactly, keeping the bits that carry out of
+// the 128 in ext, so that no realistic dot product can overflow it.
+type wideSum struct {
+	ext int64
+	x   int128
+}
+
+func (s *wideSum) add(y int128) {
+	lo, carry := bits.Add64(s.x.lo, y.lo, 0)
+	hi, carry := bits.Add64(s.x.hi, y.hi, carry)
+	s.x = int128{hi, lo}
+	s.ext += int64(carry)
+	if y.negative() {
+		s.ext--
+	}
+}
+
+// fixed rounds a sum of products, which have 2*fracBits fractional bits,
+// to the active format with r, counting an overflow against o if it does
+// not fit.
+func (s wideSum) fixed(o op, r Rounding) fixed {
+	if s.ext != int64(s.x.hi)>>63 {
+		return overflowed(o, s.ext >= 0, fixed(s.x.shr(fracBits, r).lo))
+	}
+	return s.x.shr(fracBits, r).fixed(o)
+}
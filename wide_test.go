@@ -0,0 +1,243 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+var (
+	testFormats   = []Format{Q16_48, Q32_32, Q8_24, Q16_16, Q4_12, Q8_8}
+	testRoundings = []Rounding{Truncate, RoundHalfUp, RoundHalfEven}
+)
+
+// withFormat runs fn under f, a and r and restores the defaults after.
+func withFormat(t *testing.T, f Format, a Arithmetic, r Rounding, fn func()) {
+	t.Helper()
+	if err := SetFormat(f); err != nil {
+		t.Fatal(err)
+	}
+	SetArithmetic(a)
+	SetRounding(r)
+	defer func() {
+		SetFormat(Q16_48)
+		SetArithmetic(Wrap)
+		SetRounding(RoundHalfEven)
+	}()
+	fn()
+}
+
+// edges are the values at and around the ends of the active format.
+func edges() []fixed {
+	return []fixed{MIN, MIN + 1, -ONE, -1, 0, 1, ONE, MAX - 1, MAX}
+}
+
+// randomFixed returns a value of the active format with a random number of
+// significant bits, so small and large magnitudes are both common.
+func randomFixed(rng *rand.Rand) fixed {
+	return wrap(fixed(rng.Uint64())) >> uint(rng.Intn(format.Bits()))
+}
+
+// refProduct is a*b as a big.Int, rounded from 2*fracBits to fracBits
+// fractional bits with r. tie reports whether the dropped bits were exactly
+// one half.
+func refProduct(a, b fixed, r Rounding) (q *big.Int, tie bool) {
+	return refRound(new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(b))), r)
+}
+
+// refRound rounds p, with 2*fracBits fractional bits, to fracBits with r.
+func refRound(p *big.Int, r Rounding) (q *big.Int, tie bool) {
+	return refShift(p, fracBits, r)
+}
+
+// refShift rounds p / 2^n to an integer with r.
+func refShift(p *big.Int, n uint, r Rounding) (q *big.Int, tie bool) {
+	den := new(big.Int).Lsh(big.NewInt(1), n)
+	// Euclidean division: q is the floor and rem is in [0, den)
+	q, rem := new(big.Int).DivMod(p, den, new(big.Int))
+	c := new(big.Int).Lsh(rem, 1).Cmp(den)
+	switch {
+	case r == RoundHalfUp && c >= 0,
+		r == RoundHalfEven && (c > 0 || c == 0 && q.Bit(0) == 1):
+		q.Add(q, big.NewInt(1))
+	}
+	return q, c == 0
+}
+
+// refFit is what the active arithmetic mode makes of the exact result q,
+// and whether q overflowed upwards or downwards.
+func refFit(q *big.Int) (v fixed, up, down bool) {
+	up = q.Cmp(big.NewInt(int64(MAX))) > 0
+	down = q.Cmp(big.NewInt(int64(MIN))) < 0
+	switch {
+	case up && arithmetic == Saturate:
+		return MAX, up, down
+	case down && arithmetic == Saturate:
+		return MIN, up, down
+	}
+	low := new(big.Int).And(q, new(big.Int).SetUint64(^uint64(0)))
+	return wrap(fixed(low.Uint64())), up, down
+}
+
+func TestMul128(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mod := new(big.Int).Lsh(big.NewInt(1), 128)
+	check := func(a, b fixed) {
+		x := mul128(a, b)
+		got := new(big.Int).Lsh(new(big.Int).SetUint64(x.hi), 64)
+		got.Or(got, new(big.Int).SetUint64(x.lo))
+		want := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(b)))
+		if want.Sign() < 0 {
+			want.Add(want, mod)
+		}
+		if got.Cmp(want) != 0 {
+			t.Fatalf("mul128(%d, %d) = %x:%x, want %x", a, b, x.hi, x.lo, want)
+		}
+	}
+	wide := []fixed{-1 << 63, -1<<63 + 1, -1, 0, 1, 1<<63 - 1}
+	for _, a := range wide {
+		for _, b := range wide {
+			check(a, b)
+		}
+	}
+	for i := 0; i < 100000; i++ {
+		check(fixed(rng.Uint64()), fixed(rng.Uint64())>>uint(rng.Intn(64)))
+	}
+}
+
+func TestMultiplyFixedRound(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, f := range testFormats {
+		for _, a := range []Arithmetic{Wrap, Saturate} {
+			for _, r := range testRoundings {
+				withFormat(t, f, a, r, func() {
+					var ties int
+					check := func(x, y fixed) {
+						ResetCounters()
+						got := MultiplyFixedRound(x, y, r)
+						q, tie := refProduct(x, y, r)
+						want, up, down := refFit(q)
+						if tie {
+							ties++
+						}
+						if got != want {
+							t.Fatalf("%v %v %v: %d * %d = %d, want %d", f, a, r, x, y, got, want)
+						}
+						c := ReadCounters()[opMul]
+						if (c.Overflow == 1) != up || (c.Underflow == 1) != down {
+							t.Fatalf("%v %v %v: %d * %d counted %+v, want up %v down %v", f, a, r, x, y, c, up, down)
+						}
+					}
+					for _, x := range edges() {
+						for _, y := range edges() {
+							check(x, y)
+						}
+					}
+					for i := 0; i < 20000; i++ {
+						check(randomFixed(rng), randomFixed(rng))
+					}
+					// an odd x times an odd multiple of ONE_HALF drops exactly
+					// one half
+					for i := 0; i < 2000; i++ {
+						x := randomFixed(rng) | 1
+						y := fixed(2*rng.Intn(7)+1) * ONE_HALF
+						if rng.Intn(2) == 0 {
+							y = -y
+						}
+						check(x, y)
+					}
+					if ties < 2000 {
+						t.Fatalf("%v %v %v: only %d ties", f, a, r, ties)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestMultiplyFixedSaturates(t *testing.T) {
+	for _, f := range testFormats {
+		for _, r := range testRoundings {
+			withFormat(t, f, Saturate, r, func() {
+				cases := []struct {
+					a, b, want fixed
+				}{
+					{MAX, MAX, MAX},
+					{MIN, MIN, MAX},
+					{MAX, MIN, MIN},
+					{MIN, MAX, MIN},
+					{MIN, -ONE, MAX},
+					{MAX, ONE + 1, MAX},
+					{MIN, ONE + 1, MIN},
+					{MAX, -ONE - 1, MIN},
+					{MIN, ONE, MIN},
+					{MAX, ONE, MAX},
+				}
+				for _, c := range cases {
+					if got := MultiplyFixedRound(c.a, c.b, r); got != c.want {
+						t.Errorf("%v %v: %d * %d = %d, want %d", f, r, c.a, c.b, got, c.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestWideAccumulator checks sums where rounding, or saturating, every
+// term gives a different answer than rounding once at the end.
+func TestWideAccumulator(t *testing.T) {
+	defer ResetCounters()
+	row := func(v ...fixed) *Matrix { return NewMatrix(1, len(v), v) }
+	ones := func(n int, v fixed) *Matrix {
+		m := NewMatrix(n, 1, nil)
+		for i := 0; i < n; i++ {
+			m.Set(i, 0, v)
+		}
+		return m
+	}
+	// in Q8.8, before withFormat sets it
+	const one, top = 1 << 8, 1<<15 - 1
+	// 256 products of 1/16 ulp, or 16 of exactly half an ulp
+	sixteenth := make([]fixed, 256)
+	for i := range sixteenth {
+		sixteenth[i] = 1 << 2
+	}
+	halves := make([]fixed, 16)
+	for i := range halves {
+		halves[i] = 1
+	}
+	cases := []struct {
+		name         string
+		a            Arithmetic
+		r            Rounding
+		x, y         *Matrix
+		narrow, wide fixed
+		overflows    uint64 // counted by Narrow
+	}{
+		{"1/16 ulp", Wrap, RoundHalfEven, row(sixteenth...), ones(256, 1<<2), 0, 16, 0},
+		{"1/16 ulp up", Wrap, RoundHalfUp, row(sixteenth...), ones(256, 1<<2), 0, 16, 0},
+		{"half ulp even", Wrap, RoundHalfEven, row(halves...), ones(16, one/2), 0, 8, 0},
+		{"half ulp up", Wrap, RoundHalfUp, row(halves...), ones(16, one/2), 16, 8, 0},
+		{"half ulp truncate", Wrap, Truncate, row(-1, -1, -1, -1), ones(4, one/2), -4, -2, 0},
+		{"partial sum saturates", Saturate, RoundHalfEven, row(100*one, 100*one, -100*one), ones(3, one), top - 100*one, 100 * one, 1},
+		{"partial sum wraps", Wrap, RoundHalfEven, row(100*one, 100*one, -100*one), ones(3, one), 100 * one, 100 * one, 2},
+	}
+	for _, c := range cases {
+		withFormat(t, Q8_8, c.a, c.r, func() {
+			for acc, want := range map[Accumulator]fixed{Narrow: c.narrow, Wide: c.wide} {
+				got := NewMatrix(1, 1, nil)
+				ResetCounters()
+				if err := got.ProductWith(acc, c.x, false, c.y, false); err != nil {
+					t.Fatal(err)
+				}
+				if got.At(0, 0) != want {
+					t.Errorf("%s %v: %v sum = %d ulp, want %d", c.name, c.r, acc, got.At(0, 0), want)
+				}
+				n := ReadCounters()[opProduct]
+				if want := map[Accumulator]uint64{Narrow: c.overflows}[acc]; n.Overflow+n.Underflow != want {
+					t.Errorf("%s %v: %v counted %+v, want %d", c.name, c.r, acc, n, want)
+				}
+			}
+		})
+	}
+}